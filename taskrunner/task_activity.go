@@ -0,0 +1,117 @@
+package taskrunner
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// taskActivity counts the work of a runner which does not wait on its clock:
+// the tasks queued or running and the timer fires in progress. A runner
+// driven by a timeutils.Settler clock, such as timeutils.FakeClock, waits
+// for it to settle after every timer so that advancing the clock in a test
+// runs the tasks due on the way one fire time after the other.
+type taskActivity struct {
+	mutex sync.Mutex
+	busy  int
+	// running counts the closures on a worker and the timer fires, they
+	// progress while the scheduler is blocked.
+	running int
+	// blocked is set while the scheduler waits on the clock for the rate
+	// limit, the queued tasks cannot progress before the clock moves.
+	blocked  bool
+	changeCh chan struct{}
+}
+
+func newTaskActivity() *taskActivity {
+	return &taskActivity{
+		changeCh: make(chan struct{}),
+	}
+}
+
+func (m *taskActivity) add(delta int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.busy += delta
+	m.changedLocked()
+}
+
+func (m *taskActivity) addRunning(delta int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.running += delta
+	m.changedLocked()
+}
+
+func (m *taskActivity) setBlocked(blocked bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.blocked = blocked
+	m.changedLocked()
+}
+
+// changed wakes the settle waiting for a state it does not track, the pause.
+func (m *taskActivity) changed() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.changedLocked()
+}
+
+func (m *taskActivity) changedLocked() {
+	close(m.changeCh)
+	m.changeCh = make(chan struct{})
+}
+
+// settled returns true if no work can progress without the clock, otherwise
+// the returned channel is closed on the next change.
+func (m *taskActivity) settled() (bool, <-chan struct{}) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.running == 0 && (m.busy == 0 || m.blocked), m.changeCh
+}
+
+const (
+	taskIdle int32 = iota
+	taskBusy
+	taskFinished
+)
+
+// markTaskBusy counts task from the time it is queued, markTaskIdle stops
+// counting it while it waits on the clock for a retry or a rate limit and
+// markTaskFinished once it completed, a finished task is never counted again.
+func (m *TaskRunner) markTaskBusy(task *taskItem) {
+	if atomic.CompareAndSwapInt32(&task.busy, taskIdle, taskBusy) {
+		m.activity.add(1)
+	}
+}
+
+func (m *TaskRunner) markTaskIdle(task *taskItem) {
+	if atomic.CompareAndSwapInt32(&task.busy, taskBusy, taskIdle) {
+		m.activity.add(-1)
+	}
+}
+
+func (m *TaskRunner) markTaskFinished(task *taskItem) {
+	if atomic.SwapInt32(&task.busy, taskFinished) == taskBusy {
+		m.activity.add(-1)
+	}
+}
+
+// settle is the settle func of the clock, it returns once the tasks started
+// by a timer completed or wait on the clock. A runner not running or paused
+// is settled, and the closures must not wait on the clock themselves.
+func (m *TaskRunner) settle() {
+	for {
+		if atomic.LoadInt32(&m.state) != taskRunnerStateRunning || m.pauseGate.isPaused() {
+			return
+		}
+		settled, changeCh := m.activity.settled()
+		if settled {
+			return
+		}
+		select {
+		case <-changeCh:
+		case <-m.quitCh:
+			return
+		}
+	}
+}
@@ -1,7 +1,76 @@
 package taskrunner
 
+import (
+	"context"
+)
+
 type TaskItemId int64
 
 type TaskClosure interface {
 	Run()
 }
+
+// TaskContextClosure is the context aware variant of TaskClosure. The ctx is
+// cancelled by TaskRunner.CancelTask or when the deadline/timeout of the task
+// expires, closures are expected to return soon after ctx.Done() is closed.
+type TaskContextClosure interface {
+	RunContext(ctx context.Context)
+}
+
+// TaskResultClosure is the variant of TaskContextClosure which reports a result
+// and an error through the TaskFuture returned by TaskRunner.SubmitTask.
+type TaskResultClosure interface {
+	RunResult(ctx context.Context) (interface{}, error)
+}
+
+// TaskFunc adapts an ordinary function to TaskClosure.
+type TaskFunc func()
+
+func (f TaskFunc) Run() {
+	f()
+}
+
+// TaskContextFunc adapts an ordinary function to TaskContextClosure.
+type TaskContextFunc func(ctx context.Context)
+
+func (f TaskContextFunc) RunContext(ctx context.Context) {
+	f(ctx)
+}
+
+// TaskResultFunc adapts an ordinary function to TaskResultClosure.
+type TaskResultFunc func(ctx context.Context) (interface{}, error)
+
+func (f TaskResultFunc) RunResult(ctx context.Context) (interface{}, error) {
+	return f(ctx)
+}
+
+type contextClosureAdapter struct {
+	closure TaskClosure
+}
+
+func (m *contextClosureAdapter) RunContext(_ context.Context) {
+	m.closure.Run()
+}
+
+func toContextClosure(closure TaskClosure) TaskContextClosure {
+	if closure == nil {
+		return nil
+	}
+	return &contextClosureAdapter{closure: closure}
+}
+
+type resultClosureAdapter struct {
+	closure TaskContextClosure
+}
+
+func (m *resultClosureAdapter) RunResult(ctx context.Context) (interface{}, error) {
+	m.closure.RunContext(ctx)
+	return nil, nil
+}
+
+func toResultClosure(closure TaskContextClosure) TaskResultClosure {
+	if closure == nil {
+		return nil
+	}
+	return &resultClosureAdapter{closure: closure}
+}
@@ -0,0 +1,289 @@
+package taskrunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression. Both the standard 5-field form
+// "minute hour day-of-month month day-of-week" and the 6-field form with a
+// leading seconds field are accepted, as well as the descriptors @yearly,
+// @annually, @monthly, @weekly, @daily, @midnight and @hourly. A spec may be
+// prefixed with "CRON_TZ=<zone> " or "TZ=<zone> " to override its location.
+//
+// Each field supports "*", "?" (day fields only), lists "a,b", ranges "a-b"
+// and steps "*/n", "a-b/n" or "a/n". Months and weekdays also accept the
+// three letter English names, 7 is accepted as Sunday. When both day fields
+// are restricted a day matching either of them fires, as in vixie cron.
+type CronSchedule struct {
+	second   uint64
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	domStar  bool
+	dowStar  bool
+	location *time.Location
+}
+
+type cronBounds struct {
+	min   uint
+	max   uint
+	names map[string]uint
+}
+
+var (
+	cronSecondBounds = cronBounds{min: 0, max: 59}
+	cronMinuteBounds = cronBounds{min: 0, max: 59}
+	cronHourBounds   = cronBounds{min: 0, max: 23}
+	cronDomBounds    = cronBounds{min: 1, max: 31}
+	cronMonthBounds  = cronBounds{min: 1, max: 12, names: map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	cronDowBounds = cronBounds{min: 0, max: 7, names: map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 0 1 1 *",
+		"@annually": "0 0 0 1 1 *",
+		"@monthly":  "0 0 0 1 * *",
+		"@weekly":   "0 0 0 * * 0",
+		"@daily":    "0 0 0 * * *",
+		"@midnight": "0 0 0 * * *",
+		"@hourly":   "0 0 * * * *",
+	}
+)
+
+// cronSearchYears bounds the search of Next for specs which never match,
+// e.g. "0 0 30 2 *".
+const cronSearchYears = 5
+
+// ParseCronSchedule parses spec, times are evaluated in location unless spec
+// carries its own CRON_TZ/TZ prefix. A nil location means time.Local.
+func ParseCronSchedule(spec string, location *time.Location) (*CronSchedule, error) {
+	if location == nil {
+		location = time.Local
+	}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		pos := strings.IndexAny(spec, " \t")
+		if pos == -1 {
+			return nil, fmt.Errorf("ParseCronSchedule: missing fields after time zone in %q", spec)
+		}
+		zone := spec[strings.Index(spec, "=")+1 : pos]
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("ParseCronSchedule: bad time zone %q: %v", zone, err)
+		}
+		location = loc
+		spec = strings.TrimSpace(spec[pos:])
+	}
+	if strings.HasPrefix(spec, "@") {
+		expanded, found := cronDescriptors[strings.ToLower(spec)]
+		if !found {
+			return nil, fmt.Errorf("ParseCronSchedule: unknown descriptor %q", spec)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("ParseCronSchedule: expected 5 or 6 fields, found %d in %q", len(fields), spec)
+	}
+
+	schedule := &CronSchedule{
+		domStar:  isCronStar(fields[3]),
+		dowStar:  isCronStar(fields[5]),
+		location: location,
+	}
+	var err error
+	if schedule.second, err = parseCronField(fields[0], cronSecondBounds, false); err != nil {
+		return nil, err
+	}
+	if schedule.minute, err = parseCronField(fields[1], cronMinuteBounds, false); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseCronField(fields[2], cronHourBounds, false); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = parseCronField(fields[3], cronDomBounds, true); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseCronField(fields[4], cronMonthBounds, false); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = parseCronField(fields[5], cronDowBounds, true); err != nil {
+		return nil, err
+	}
+	// 7 is an alias of Sunday
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow = schedule.dow&^(1<<7) | 1
+	}
+	return schedule, nil
+}
+
+func isCronStar(field string) bool {
+	return field == "*" || field == "?"
+}
+
+func parseCronField(field string, bounds cronBounds, allowQuestion bool) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeAndStep := strings.Split(part, "/")
+		if len(rangeAndStep) > 2 {
+			return 0, fmt.Errorf("ParseCronSchedule: too many slashes in %q", part)
+		}
+		var low, high uint
+		var err error
+		switch {
+		case rangeAndStep[0] == "*" || (allowQuestion && rangeAndStep[0] == "?"):
+			low, high = bounds.min, bounds.max
+		default:
+			lowAndHigh := strings.Split(rangeAndStep[0], "-")
+			if len(lowAndHigh) > 2 {
+				return 0, fmt.Errorf("ParseCronSchedule: too many hyphens in %q", part)
+			}
+			if low, err = parseCronValue(lowAndHigh[0], bounds); err != nil {
+				return 0, err
+			}
+			high = low
+			if len(lowAndHigh) == 2 {
+				if high, err = parseCronValue(lowAndHigh[1], bounds); err != nil {
+					return 0, err
+				}
+			} else if len(rangeAndStep) == 2 {
+				// "a/n" means from a to the end of the range
+				high = bounds.max
+			}
+		}
+		step := uint(1)
+		if len(rangeAndStep) == 2 {
+			value, err := strconv.ParseUint(rangeAndStep[1], 10, 32)
+			if err != nil || value == 0 {
+				return 0, fmt.Errorf("ParseCronSchedule: bad step in %q", part)
+			}
+			step = uint(value)
+		}
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, fmt.Errorf("ParseCronSchedule: %q is out of range [%d, %d]", part, bounds.min, bounds.max)
+		}
+		for i := low; i <= high; i += step {
+			bits |= 1 << i
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, bounds cronBounds) (uint, error) {
+	if bounds.names != nil {
+		if named, found := bounds.names[strings.ToLower(value)]; found {
+			return named, nil
+		}
+	}
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("ParseCronSchedule: bad value %q", value)
+	}
+	return uint(parsed), nil
+}
+
+// Location returns the time zone the schedule is evaluated in.
+func (m *CronSchedule) Location() *time.Location {
+	return m.location
+}
+
+// Next returns the first fire time strictly after t, or the zero time if the
+// schedule does not match within the next few years.
+func (m *CronSchedule) Next(t time.Time) time.Time {
+	origLocation := t.Location()
+	loc := m.location
+	t = t.In(loc)
+	// start from the next whole second
+	t = t.Add(time.Second - time.Duration(t.Nanosecond())*time.Nanosecond)
+	yearLimit := t.Year() + cronSearchYears
+	// added is set once a field has been advanced, the lower fields are then
+	// reset to their minimum
+	added := false
+
+WRAP:
+	for t.Year() <= yearLimit {
+		for 1<<uint(t.Month())&m.month == 0 {
+			if !added {
+				added = true
+				t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+			}
+			t = t.AddDate(0, 1, 0)
+			if t.Month() == time.January {
+				continue WRAP
+			}
+		}
+		for !m.dayMatches(t) {
+			if !added {
+				added = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+			}
+			t = t.AddDate(0, 0, 1)
+			// a DST transition at midnight may shift the hour
+			if t.Hour() != 0 {
+				if t.Hour() > 12 {
+					t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+				} else {
+					t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+				}
+			}
+			if t.Day() == 1 {
+				continue WRAP
+			}
+		}
+		for 1<<uint(t.Hour())&m.hour == 0 {
+			if !added {
+				added = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+			}
+			t = t.Add(time.Hour)
+			if t.Hour() == 0 {
+				continue WRAP
+			}
+		}
+		for 1<<uint(t.Minute())&m.minute == 0 {
+			if !added {
+				added = true
+				t = t.Truncate(time.Minute)
+			}
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue WRAP
+			}
+		}
+		for 1<<uint(t.Second())&m.second == 0 {
+			if !added {
+				added = true
+				t = t.Truncate(time.Second)
+			}
+			t = t.Add(time.Second)
+			if t.Second() == 0 {
+				continue WRAP
+			}
+		}
+		return t.In(origLocation)
+	}
+	return time.Time{}
+}
+
+func (m *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := 1<<uint(t.Day())&m.dom != 0
+	dowMatch := 1<<uint(t.Weekday())&m.dow != 0
+	if m.domStar || m.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,151 @@
+package taskrunner
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, minute, second int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		// steps and ranges
+		{"*/15 * * * *", utc(2024, 1, 1, 10, 7, 30), utc(2024, 1, 1, 10, 15, 0)},
+		{"0 9-17/4 * * *", utc(2024, 1, 1, 10, 0, 0), utc(2024, 1, 1, 13, 0, 0)},
+		{"0 9-17/4 * * *", utc(2024, 1, 1, 17, 30, 0), utc(2024, 1, 2, 9, 0, 0)},
+		{"5/20 * * * * *", utc(2024, 1, 1, 10, 0, 26), utc(2024, 1, 1, 10, 0, 45)},
+		{"0 0 1,15 * *", utc(2024, 1, 2, 0, 0, 0), utc(2024, 1, 15, 0, 0, 0)},
+		{"0 0 * * *", utc(2024, 12, 31, 12, 0, 0), utc(2025, 1, 1, 0, 0, 0)},
+		// strictly after from
+		{"0 0 * * *", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 2, 0, 0, 0)},
+		{"30 0 0 * * *", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 1, 0, 0, 30)},
+		// names, 2024-01-06 is a Saturday
+		{"0 0 * * mon-fri", utc(2024, 1, 6, 0, 0, 0), utc(2024, 1, 8, 0, 0, 0)},
+		{"0 0 1 JAN *", utc(2024, 3, 1, 0, 0, 0), utc(2025, 1, 1, 0, 0, 0)},
+		{"0 0 * jun-aug sun", utc(2024, 1, 1, 0, 0, 0), utc(2024, 6, 2, 0, 0, 0)},
+		// 7 is Sunday
+		{"0 0 * * 7", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 7, 0, 0, 0)},
+		{"0 0 * * 5-7", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 5, 0, 0, 0)},
+		// either restricted day field matches
+		{"0 0 13 * fri", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 5, 0, 0, 0)},
+		{"0 0 13 * fri", utc(2024, 1, 12, 0, 0, 0), utc(2024, 1, 13, 0, 0, 0)},
+		{"0 0 13 * ?", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 13, 0, 0, 0)},
+		// descriptors
+		{"@hourly", utc(2024, 1, 1, 10, 15, 0), utc(2024, 1, 1, 11, 0, 0)},
+		{"@daily", utc(2024, 1, 1, 10, 15, 0), utc(2024, 1, 2, 0, 0, 0)},
+		{"@midnight", utc(2024, 1, 1, 10, 15, 0), utc(2024, 1, 2, 0, 0, 0)},
+		{"@weekly", utc(2024, 1, 1, 0, 0, 0), utc(2024, 1, 7, 0, 0, 0)},
+		{"@monthly", utc(2024, 1, 15, 0, 0, 0), utc(2024, 2, 1, 0, 0, 0)},
+		{"@yearly", utc(2024, 1, 15, 0, 0, 0), utc(2025, 1, 1, 0, 0, 0)},
+		{"@annually", utc(2024, 1, 15, 0, 0, 0), utc(2025, 1, 1, 0, 0, 0)},
+		// leap day within the search window
+		{"0 0 29 2 *", utc(2024, 3, 1, 0, 0, 0), utc(2028, 2, 29, 0, 0, 0)},
+		// never fires
+		{"0 0 30 2 *", utc(2024, 1, 1, 0, 0, 0), time.Time{}},
+		{"0 0 31 4,6,9,11 *", utc(2024, 1, 1, 0, 0, 0), time.Time{}},
+		// the prefixed zone overrides the location, 09:00 in Tokyo is 00:00 UTC
+		{"CRON_TZ=Asia/Tokyo 0 9 * * *", utc(2024, 1, 1, 0, 30, 0), utc(2024, 1, 2, 0, 0, 0)},
+		{"TZ=Asia/Tokyo 0 9 * * *", utc(2023, 12, 31, 23, 0, 0), utc(2024, 1, 1, 0, 0, 0)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec, time.UTC)
+		if err != nil {
+			t.Errorf("%q: %v", test.spec, err)
+			continue
+		}
+		if next := schedule.Next(test.from); !next.Equal(test.next) {
+			t.Errorf("%q from %v: next %v, want %v", test.spec, test.from, next, test.next)
+		} else if !next.IsZero() && next.Location() != test.from.Location() {
+			t.Errorf("%q: next in %v, want the location of from", test.spec, next.Location())
+		}
+	}
+}
+
+func TestCronScheduleDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2024, month, day, hour, minute, 0, 0, newYork)
+	}
+	// the clocks jump from 02:00 to 03:00 on 2024-03-10 and back from 02:00
+	// to 01:00 on 2024-11-03
+	tests := []struct {
+		spec string
+		from time.Time
+		next time.Time
+	}{
+		// the skipped time does not fire that day
+		{"30 2 * * *", local(3, 9, 3, 0), local(3, 11, 2, 30)},
+		{"0 * * * *", local(3, 10, 1, 30), local(3, 10, 3, 0)},
+		{"0 3 * * *", local(3, 9, 12, 0), local(3, 10, 3, 0)},
+		{"0 0 * * *", local(3, 9, 12, 0), local(3, 10, 0, 0)},
+		{"0 0 * * *", local(3, 10, 0, 0), local(3, 11, 0, 0)},
+		// the repeated time fires once
+		{"30 1 * * *", local(11, 3, 0, 0), local(11, 3, 1, 30)},
+		{"0 0 * * *", local(11, 2, 12, 0), local(11, 3, 0, 0)},
+		{"0 0 * * *", local(11, 3, 0, 0), local(11, 4, 0, 0)},
+	}
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.spec, newYork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := schedule.Next(test.from); !next.Equal(test.next) {
+			t.Errorf("%q from %v: next %v, want %v", test.spec, test.from, next, test.next)
+		}
+	}
+	if location := mustParseCron(t, "0 0 * * *", nil).Location(); location != time.Local {
+		t.Errorf("location %v, want Local", location)
+	}
+}
+
+func mustParseCron(t *testing.T, spec string, location *time.Location) *CronSchedule {
+	schedule, err := ParseCronSchedule(spec, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"60 * * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"*/2/3 * * * *",
+		"1-2-3 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+		"? * * * *",
+		"* * * foo *",
+		"* * * * funday",
+		"1,,2 * * * *",
+		"@every 5m",
+		"@reboot",
+		"CRON_TZ=UTC",
+		"CRON_TZ=Nowhere/City * * * * *",
+	}
+	for _, spec := range specs {
+		if _, err := ParseCronSchedule(spec, time.UTC); err == nil {
+			t.Errorf("%q: no error", spec)
+		}
+	}
+}
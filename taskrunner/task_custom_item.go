@@ -1,94 +1,379 @@
 package taskrunner
 
 import (
+	"context"
+	"sync"
 	"sync/atomic"
 	"time"
+
+	"k8s.io/klog/v2"
 )
 
 type customTaskItem interface {
 	startSchedule()
 	terminate()
-	run()
+	cancel()
+	nextFireTime() time.Time
+	info() *TaskInfo
+	pause()
+	resume()
+	isPaused() bool
+}
+
+// timedTask is the state shared by the repeating, delayed and cron tasks,
+// which are fired by the taskTimer of their runner.
+type timedTask struct {
+	schedulePause
+	id               TaskItemId
+	closure          TaskResultClosure
+	options          *taskOptions
+	ctx              context.Context
+	cancelFunc       context.CancelFunc
+	taskRunner       *TaskRunner
+	nextFireTimeInNs int64
+
+	mutex       sync.Mutex
+	entry       *timerEntry
+	stopped     bool
+	stopWatchFn func() bool
+}
+
+func (m *timedTask) init(id TaskItemId, closure TaskResultClosure, options *taskOptions, taskRunner *TaskRunner) {
+	m.id = id
+	m.closure = closure
+	m.options = options
+	m.taskRunner = taskRunner
+	m.ctx, m.cancelFunc = options.newContext()
+}
+
+func (m *timedTask) cancel() {
+	m.cancelFunc()
+}
+
+func (m *timedTask) nextFireTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&m.nextFireTimeInNs))
+}
+
+func (m *timedTask) isStopped() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.stopped
+}
+
+// scheduleAt arms the timer unless the task was stopped.
+func (m *timedTask) scheduleAt(when time.Time, fire func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stopped {
+		return
+	}
+	atomic.StoreInt64(&m.nextFireTimeInNs, when.UnixNano())
+	m.entry = m.taskRunner.timer.schedule(when, fire)
+}
+
+// watchContext calls onDone once ctx is done while the task is scheduled.
+func (m *timedTask) watchContext(onDone func()) {
+	stopWatch := context.AfterFunc(m.ctx, onDone)
+	m.mutex.Lock()
+	m.stopWatchFn = stopWatch
+	m.mutex.Unlock()
+}
+
+// stop disarms the timer, it returns false if the task was already stopped.
+func (m *timedTask) stop() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.stopped {
+		return false
+	}
+	m.stopped = true
+	m.taskRunner.timer.cancel(m.entry)
+	if m.stopWatchFn != nil {
+		m.stopWatchFn()
+	}
+	return true
+}
+
+// fire queues a run with id, onFinish is called once with its final error
+// from the worker, before the run counts as completed, or right away if the
+// run was not queued.
+func (m *timedTask) fire(id TaskItemId, onFinish func(err error)) *TaskFuture {
+	var once sync.Once
+	finish := func(err error) {
+		if onFinish != nil {
+			once.Do(func() {
+				onFinish(err)
+			})
+		}
+	}
+	if m.options.singleton && !m.taskRunner.IsLeader() {
+		klog.V(1).Infof("SkipSingletonFire TaskRunner:%s Id:%d", m.taskRunner.name, m.id)
+		finish(ErrNotLeader)
+		return newCompletedTaskFuture(id, ErrNotLeader)
+	}
+	options := m.options.runOptions(m.ctx)
+	if taskFinish := options.onFinish; taskFinish != nil {
+		options.onFinish = func(err error) {
+			taskFinish(err)
+			finish(err)
+		}
+	} else {
+		options.onFinish = finish
+	}
+	future, err := m.taskRunner.addTaskInternal(id, m.closure, options, false)
+	if err != nil {
+		finish(err)
+	} else if future.Id() != id {
+		// coalesced with a pending task by its dedup key
+		go func() {
+			_, err := future.Wait()
+			finish(err)
+		}()
+	}
+	return future
 }
 
 type repeatTaskItem struct {
-	id                    TaskItemId
-	closure               TaskClosure
+	timedTask
 	repeatingIntervalInMs int64
-	ticker                *time.Ticker
-	stopCh                chan bool
-	isStopped             int32
-	taskRunner            *TaskRunner
+	skippedRuns           int32
+	// scheduled is the fire time without jitter, a fixed-rate schedule does
+	// not drift with the jitter or a late fire.
+	scheduled time.Time
+	// running is set from a fixed-rate fire until its run finished, queued is
+	// set by OverlapQueueOne while a fire waits for the running one.
+	running bool
+	queued  bool
 }
 
 func (m *repeatTaskItem) startSchedule() {
-	go m.run()
+	m.watchContext(func() {
+		m.stop()
+		m.taskRunner.RemoveTask(m.id)
+	})
+	m.mutex.Lock()
+	m.scheduled = m.taskRunner.clock.Now().Add(time.Duration(m.options.initialDelayInMs) * time.Millisecond)
+	scheduled := m.scheduled
+	m.mutex.Unlock()
+	m.scheduleAt(scheduled.Add(m.options.repeatJitter()), m.onFire)
 }
 
 func (m *repeatTaskItem) terminate() {
-	if atomic.LoadInt32(&m.isStopped) == 0 {
-		m.stopCh <- true
-	}
-}
-
-func (m *repeatTaskItem) run() {
-	m.ticker = time.NewTicker(time.Duration(m.repeatingIntervalInMs) * time.Millisecond)
-	defer m.ticker.Stop()
-	for {
-		m.taskRunner.addTaskInternal(m.id, m.closure)
-		select {
-		case <-m.ticker.C:
-			continue
-		case stop := <-m.stopCh:
-			if stop {
-				atomic.AddInt32(&m.isStopped, 1)
-				break
-			}
+	m.stop()
+}
+
+func (m *repeatTaskItem) info() *TaskInfo {
+	info := m.options.describe(m.id)
+	info.NextFireTime = m.nextFireTime()
+	info.Paused = m.isPaused()
+	info.SkippedRuns = int(atomic.LoadInt32(&m.skippedRuns))
+	return info
+}
+
+func (m *repeatTaskItem) resume() {
+	m.schedulePause.resume()
+}
+
+func (m *repeatTaskItem) onFire() {
+	if m.isStopped() {
+		return
+	}
+	interval := time.Duration(m.repeatingIntervalInMs) * time.Millisecond
+	if m.options.repeatMode == RepeatFixedDelay {
+		if m.isPaused() {
+			m.scheduleAt(m.taskRunner.clock.Now().Add(interval).Add(m.options.repeatJitter()), m.onFire)
+			return
 		}
-		if atomic.LoadInt32(&m.isStopped) > 0 {
-			break
+		m.fire(m.id, func(err error) {
+			m.scheduleAt(m.taskRunner.clock.Now().Add(interval).Add(m.options.repeatJitter()), m.onFire)
+		})
+		return
+	}
+	if !m.isPaused() {
+		m.fireFixedRate()
+	}
+	m.mutex.Lock()
+	m.scheduled = m.scheduled.Add(interval)
+	if now := m.taskRunner.clock.Now(); m.scheduled.Before(now) {
+		// the fires missed while the process was stalled are lost
+		m.scheduled = m.scheduled.Add(now.Sub(m.scheduled).Truncate(interval) + interval)
+	}
+	scheduled := m.scheduled
+	m.mutex.Unlock()
+	m.scheduleAt(scheduled.Add(m.options.repeatJitter()), m.onFire)
+}
+
+// fireFixedRate applies the OverlapPolicy when the last run is still pending.
+func (m *repeatTaskItem) fireFixedRate() {
+	m.mutex.Lock()
+	if !m.running {
+		m.running = true
+		m.mutex.Unlock()
+		m.fire(m.id, m.onRunFinished)
+		return
+	}
+	switch m.options.overlapPolicy {
+	case OverlapQueueOne:
+		if !m.queued {
+			m.queued = true
+			m.mutex.Unlock()
+			return
 		}
+	case OverlapAllowConcurrent:
+		m.mutex.Unlock()
+		m.fire(m.taskRunner.getUniqueTaskId(), nil)
+		return
+	}
+	m.mutex.Unlock()
+	atomic.AddInt32(&m.skippedRuns, 1)
+	klog.V(1).Infof("RepeatTaskFireSkipped TaskRunner:%s Id:%d Policy:%v", m.taskRunner.name, m.id, m.options.overlapPolicy)
+}
+
+// onRunFinished fires the run queued by OverlapQueueOne. It is called by the
+// worker of the last run, which must not block on a full queue, so the fire
+// goes through the timer.
+func (m *repeatTaskItem) onRunFinished(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.queued && !m.stopped {
+		m.queued = false
+		m.taskRunner.timer.schedule(m.taskRunner.clock.Now(), m.fireQueued)
+		return
 	}
+	m.queued = false
+	m.running = false
+}
+
+func (m *repeatTaskItem) fireQueued() {
+	m.mutex.Lock()
+	if m.stopped {
+		m.running = false
+		m.mutex.Unlock()
+		return
+	}
+	m.mutex.Unlock()
+	m.fire(m.id, m.onRunFinished)
 }
 
 type delayedTaskItem struct {
-	id              TaskItemId
-	closure         TaskClosure
+	timedTask
 	delayedTimeInMs int64
-	stopCh          chan bool
-	isStopped       int32
-	taskRunner      *TaskRunner
+	fireTime        time.Time
+	// due is set when the fire time passed while the task was paused.
+	due bool
 }
 
 func (m *delayedTaskItem) startSchedule() {
-	go m.run()
+	m.watchContext(func() {
+		if m.stop() {
+			m.finishUnfired(m.ctx.Err())
+		}
+		m.taskRunner.RemoveTask(m.id)
+	})
+	m.scheduleAt(m.fireTime, m.onFire)
 }
 
 func (m *delayedTaskItem) terminate() {
-	if atomic.LoadInt32(&m.isStopped) == 0 {
-		m.stopCh <- true
-	}
-}
-
-func (m *delayedTaskItem) run() {
-	ticker := time.NewTicker(time.Duration(m.delayedTimeInMs) * time.Millisecond)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ticker.C:
-			if atomic.LoadInt32(&m.isStopped) > 0 {
-				break
-			}
-			_ = m.taskRunner.addTaskInternal(m.id, m.closure)
-			m.taskRunner.RemoveTask(m.id)
-			atomic.AddInt32(&m.isStopped, 1)
-		case stop := <-m.stopCh:
-			if stop {
-				atomic.AddInt32(&m.isStopped, 1)
-			}
-		}
-		if atomic.LoadInt32(&m.isStopped) > 0 {
-			break
-		}
+	if m.stop() {
+		m.finishUnfired(context.Canceled)
+	}
+}
+
+func (m *delayedTaskItem) nextFireTime() time.Time {
+	return m.fireTime
+}
+
+func (m *delayedTaskItem) info() *TaskInfo {
+	info := m.options.describe(m.id)
+	info.NextFireTime = m.nextFireTime()
+	info.Paused = m.isPaused()
+	return info
+}
+
+func (m *delayedTaskItem) resume() {
+	m.schedulePause.resume()
+	m.mutex.Lock()
+	due := m.due
+	m.mutex.Unlock()
+	if due {
+		go m.onFire()
+	}
+}
+
+func (m *delayedTaskItem) onFire() {
+	m.mutex.Lock()
+	if m.stopped {
+		m.mutex.Unlock()
+		return
+	}
+	if m.isPaused() {
+		m.due = true
+		m.mutex.Unlock()
+		return
+	}
+	m.mutex.Unlock()
+	// the task is stopped before it is queued so that a concurrent terminate
+	// does not report it as unfired
+	if !m.stop() {
+		return
+	}
+	m.fire(m.id, nil)
+	m.taskRunner.RemoveTask(m.id)
+}
+
+// finishUnfired reports a delayed task stopped before it fired, a stop caused
+// by Shutdown is reported as ErrTaskRunnerShutdown.
+func (m *delayedTaskItem) finishUnfired(err error) {
+	if m.options.onFinish == nil {
+		return
+	}
+	if !m.taskRunner.isAcceptingTasks() {
+		err = ErrTaskRunnerShutdown
+	}
+	m.options.onFinish(err)
+}
+
+type cronTaskItem struct {
+	timedTask
+	schedule *CronSchedule
+}
+
+func (m *cronTaskItem) startSchedule() {
+	m.watchContext(func() {
+		m.stop()
+		m.taskRunner.RemoveTask(m.id)
+	})
+	m.scheduleAt(m.schedule.Next(m.taskRunner.clock.Now()), m.onFire)
+}
+
+func (m *cronTaskItem) terminate() {
+	m.stop()
+}
+
+func (m *cronTaskItem) info() *TaskInfo {
+	info := m.options.describe(m.id)
+	info.NextFireTime = m.nextFireTime()
+	info.Paused = m.isPaused()
+	return info
+}
+
+func (m *cronTaskItem) resume() {
+	m.schedulePause.resume()
+}
+
+func (m *cronTaskItem) onFire() {
+	if m.isStopped() {
+		return
+	}
+	if !m.isPaused() {
+		m.fire(m.id, nil)
+	}
+	next := m.schedule.Next(m.taskRunner.clock.Now())
+	if next.IsZero() {
+		klog.Warningf("CronTaskExhausted TaskRunner:%s Id:%d", m.taskRunner.name, m.id)
+		m.stop()
+		m.taskRunner.RemoveTask(m.id)
+		return
 	}
+	m.scheduleAt(next, m.onFire)
 }
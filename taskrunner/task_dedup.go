@@ -0,0 +1,175 @@
+package taskrunner
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sohuno/gotools/timeutils"
+
+	"k8s.io/klog/v2"
+)
+
+// DedupPolicy decides what happens to a task submitted with the dedup key of
+// a task that was submitted before.
+type DedupPolicy int
+
+const (
+	// DedupDropDuplicate drops the new closure while the earlier task has not
+	// started yet, the caller gets the future of the earlier task.
+	DedupDropDuplicate DedupPolicy = iota
+	// DedupReplacePending replaces the closure of the earlier task with the
+	// new one while it has not started yet. A task waiting for a retry has
+	// started, its attempts all run the same closure and the new closure
+	// becomes a task of its own.
+	DedupReplacePending
+	// DedupDebounce holds a task back until no task with the same key was
+	// submitted for the window, then runs the newest closure once.
+	DedupDebounce
+	// DedupThrottle accepts at most one task per window, the tasks submitted
+	// within the window get the future of the accepted one.
+	DedupThrottle
+)
+
+func (p DedupPolicy) String() string {
+	switch p {
+	case DedupDropDuplicate:
+		return "DropDuplicate"
+	case DedupReplacePending:
+		return "ReplacePending"
+	case DedupDebounce:
+		return "Debounce"
+	case DedupThrottle:
+		return "Throttle"
+	default:
+		return "Unknown"
+	}
+}
+
+// WithDedupKey coalesces tasks submitted with the same key according to
+// policy, windowInMs is only used by DedupDebounce and DedupThrottle. A
+// coalesced task keeps the options of the task it was merged into.
+func WithDedupKey(key string, policy DedupPolicy, windowInMs int64) TaskOption {
+	return func(options *taskOptions) {
+		options.dedupKey = key
+		options.dedupPolicy = policy
+		options.dedupWindowInMs = windowInMs
+	}
+}
+
+type dedupEntry struct {
+	task       *taskItem
+	policy     DedupPolicy
+	window     time.Duration
+	acceptTime time.Time
+	lastSubmit time.Time
+	// timer fires the debounced task, or expires the window of a throttled
+	// task which finished early.
+	timer timeutils.Timer
+	fired bool
+}
+
+// coalesceTaskLocked returns the future of the task closure was merged into,
+// or false if a new task has to be created. m.mutex must be held.
+func (m *TaskRunner) coalesceTaskLocked(closure TaskResultClosure, options *taskOptions) (*TaskFuture, bool) {
+	entry, found := m.dedupKeys[options.dedupKey]
+	if !found {
+		return nil, false
+	}
+	now := m.clock.Now()
+	switch entry.policy {
+	case DedupDropDuplicate:
+		if entry.task.hasStarted() {
+			return nil, false
+		}
+	case DedupReplacePending:
+		if entry.task.hasStarted() {
+			return nil, false
+		}
+		entry.task.closure = closure
+	case DedupDebounce:
+		if entry.fired {
+			return nil, false
+		}
+		entry.task.closure = closure
+		entry.lastSubmit = now
+	case DedupThrottle:
+		if now.Sub(entry.acceptTime) >= entry.window {
+			return nil, false
+		}
+	}
+	klog.V(1).Infof("TaskCoalesced TaskRunner:%s Id:%d Key:%s Policy:%v", m.name, entry.task.id, options.dedupKey, entry.policy)
+	atomic.AddUint64(&m.metrics.coalesced, 1)
+	return entry.task.future, true
+}
+
+// hasStarted returns true once the task ran, also while it waits for a retry.
+// m.taskRunner.mutex must be held.
+func (m *taskItem) hasStarted() bool {
+	return m.isRunning || atomic.LoadInt32(&m.future.attempts) > 0
+}
+
+// trackDedupKeyLocked makes task the one later submissions with its key are
+// merged into. m.mutex must be held.
+func (m *TaskRunner) trackDedupKeyLocked(task *taskItem, options *taskOptions) *dedupEntry {
+	if previous, found := m.dedupKeys[task.dedupKey]; found && previous.timer != nil {
+		previous.timer.Stop()
+	}
+	now := m.clock.Now()
+	entry := &dedupEntry{
+		task:       task,
+		policy:     options.dedupPolicy,
+		window:     time.Duration(options.dedupWindowInMs) * time.Millisecond,
+		acceptTime: now,
+		lastSubmit: now,
+	}
+	m.dedupKeys[task.dedupKey] = entry
+	return entry
+}
+
+// debounceTask queues the task of entry once the window passed without a new
+// submission with its key.
+func (m *TaskRunner) debounceTask(entry *dedupEntry) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry.timer = m.clock.AfterFunc(entry.window, func() {
+		m.mutex.Lock()
+		if m.dedupKeys[entry.task.dedupKey] != entry || entry.fired {
+			m.mutex.Unlock()
+			return
+		}
+		if wait := entry.window - m.clock.Since(entry.lastSubmit); wait > 0 {
+			entry.timer.Reset(wait)
+			m.mutex.Unlock()
+			return
+		}
+		entry.fired = true
+		m.mutex.Unlock()
+		m.queueTask(entry.task, false)
+	})
+}
+
+// releaseDedupKey is called once task completed, a throttled key is kept
+// until its window expired.
+func (m *TaskRunner) releaseDedupKey(task *taskItem) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, found := m.dedupKeys[task.dedupKey]
+	if !found || entry.task != task {
+		return
+	}
+	if entry.timer != nil {
+		entry.timer.Stop()
+	}
+	remaining := entry.window - m.clock.Since(entry.acceptTime)
+	if entry.policy != DedupThrottle || remaining <= 0 {
+		delete(m.dedupKeys, task.dedupKey)
+		return
+	}
+	entry.timer = m.clock.AfterFunc(remaining, func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.dedupKeys[task.dedupKey] == entry {
+			delete(m.dedupKeys, task.dedupKey)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func dedupTestClosure(runs *int32, result string) TaskResultClosure {
+	return TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(runs, 1)
+		return result, nil
+	})
+}
+
+func TestDedupDropDuplicate(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	runner.Pause()
+	var runs int32
+	first := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "first"), WithDedupKey("key", DedupDropDuplicate, 0))
+	second := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "second"), WithDedupKey("key", DedupDropDuplicate, 0))
+	if first.Id() != second.Id() {
+		t.Fatalf("duplicate got task %d, want %d", second.Id(), first.Id())
+	}
+	runner.Resume()
+	clock.Advance(0)
+	if result, _ := second.Wait(); result != "first" || atomic.LoadInt32(&runs) != 1 {
+		t.Fatalf("result %v, runs %d", result, runs)
+	}
+	// the key is free once the task completed
+	third := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "third"), WithDedupKey("key", DedupDropDuplicate, 0))
+	if result, _ := third.Wait(); third.Id() == first.Id() || result != "third" {
+		t.Fatalf("task %d result %v", third.Id(), result)
+	}
+}
+
+func TestDedupReplacePending(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	runner.Pause()
+	var runs int32
+	first := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "first"), WithDedupKey("key", DedupReplacePending, 0))
+	second := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "second"), WithDedupKey("key", DedupReplacePending, 0))
+	if first.Id() != second.Id() {
+		t.Fatalf("replacement got task %d, want %d", second.Id(), first.Id())
+	}
+	runner.Resume()
+	clock.Advance(0)
+	if result, _ := first.Wait(); result != "second" || atomic.LoadInt32(&runs) != 1 {
+		t.Fatalf("result %v, runs %d", result, runs)
+	}
+}
+
+func TestDedupReplacePendingRetry(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	var attempts int32
+	first := runner.SubmitTask(context.Background(), TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			return nil, errors.New("failed")
+		}
+		return "first", nil
+	}), WithDedupKey("key", DedupReplacePending, 0), WithRetryPolicy(&RetryPolicy{MaxAttempts: 2, InitialBackoffInMs: 1000}))
+	clock.Advance(0)
+	if first.Attempts() != 1 {
+		t.Fatalf("%d attempts before the backoff", first.Attempts())
+	}
+	// the first task waits for its retry, it is not replaced
+	var runs int32
+	second := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "second"), WithDedupKey("key", DedupReplacePending, 0))
+	if second.Id() == first.Id() {
+		t.Fatal("closure of a task waiting for its retry replaced")
+	}
+	clock.Advance(time.Second)
+	if result, err := first.Wait(); result != "first" || err != nil {
+		t.Fatalf("retried task result %v error %v", result, err)
+	}
+	if result, _ := second.Wait(); result != "second" {
+		t.Fatalf("result %v", result)
+	}
+}
+
+func TestDedupDebounce(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	var runs int32
+	first := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "first"), WithDedupKey("key", DedupDebounce, 100))
+	clock.Advance(60 * time.Millisecond)
+	second := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "second"), WithDedupKey("key", DedupDebounce, 100))
+	if first.Id() != second.Id() {
+		t.Fatalf("debounced task %d, want %d", second.Id(), first.Id())
+	}
+	// the window restarted with the second submission
+	clock.Advance(60 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatalf("%d runs within the window", n)
+	}
+	clock.Advance(40 * time.Millisecond)
+	if result, _ := first.Wait(); result != "second" || atomic.LoadInt32(&runs) != 1 {
+		t.Fatalf("result %v, runs %d", result, runs)
+	}
+	third := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "third"), WithDedupKey("key", DedupDebounce, 100))
+	if third.Id() == first.Id() {
+		t.Fatal("completed debounced task reused")
+	}
+	clock.Advance(100 * time.Millisecond)
+	if result, _ := third.Wait(); result != "third" || atomic.LoadInt32(&runs) != 2 {
+		t.Fatalf("result %v, runs %d", result, runs)
+	}
+}
+
+func TestDedupThrottle(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	var runs int32
+	first := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "first"), WithDedupKey("key", DedupThrottle, 1000))
+	clock.Advance(0)
+	if result, _ := first.Wait(); result != "first" {
+		t.Fatalf("result %v", result)
+	}
+	// the window is kept after the task completed
+	clock.Advance(500 * time.Millisecond)
+	second := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "second"), WithDedupKey("key", DedupThrottle, 1000))
+	if second.Id() != first.Id() {
+		t.Fatalf("throttled task %d, want %d", second.Id(), first.Id())
+	}
+	clock.Advance(500 * time.Millisecond)
+	third := runner.SubmitTask(context.Background(), dedupTestClosure(&runs, "third"), WithDedupKey("key", DedupThrottle, 1000))
+	if third.Id() == first.Id() {
+		t.Fatal("task throttled after the window")
+	}
+	if result, _ := third.Wait(); result != "third" || atomic.LoadInt32(&runs) != 2 {
+		t.Fatalf("result %v, runs %d", result, runs)
+	}
+}
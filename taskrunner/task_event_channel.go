@@ -2,48 +2,255 @@ package taskrunner
 
 import (
 	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sohuno/gotools/timeutils"
+)
+
+var (
+	ErrTaskQueueFull = errors.New("task queue is full")
+	ErrTaskDropped   = errors.New("task dropped by queue overflow policy")
+)
+
+// OverflowPolicy decides what happens to a TaskEvent sent to a full channel.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the sender until there is room.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject fails the send with ErrTaskQueueFull.
+	OverflowReject
+	// OverflowDropOldest evicts the oldest event of the least urgent priority
+	// to make room, the evicted event is reported through the drop handler.
+	OverflowDropOldest
+	// OverflowDropNewest discards the event being sent with ErrTaskDropped.
+	OverflowDropNewest
 )
 
+// TaskEvent notifies the scheduler that the task with Id is ready to run.
+type TaskEvent struct {
+	Id          TaskItemId
+	Priority    TaskPriority
+	EnqueueTime time.Time
+}
+
+type TaskEventChannelConfig struct {
+	// Capacity bounds the number of buffered events, 0 means unbounded.
+	Capacity       int
+	OverflowPolicy OverflowPolicy
+	// AgingIntervalInMs promotes an event one priority level for every
+	// interval it has waited, 0 disables aging.
+	AgingIntervalInMs int64
+	// DropHandler is called with the events evicted by OverflowDropOldest.
+	DropHandler func(event TaskEvent)
+	// Clock measures the aging, it has to be the clock of the EnqueueTime of
+	// the events. timeutils.RealClock is used by default.
+	Clock timeutils.Clock
+}
+
+// TaskEventChannel buffers TaskEvents between producers and the scheduler, it
+// keeps one FIFO list per priority and always delivers the most urgent event
+// on RecvCh. With aging enabled an event is promoted one level for every
+// agingInterval it has waited, so low priority work cannot starve.
 type TaskEventChannel struct {
-	SendCh chan TaskItemId
-	RecvCh chan TaskItemId
-	queue  *list.List
+	RecvCh chan TaskEvent
+
+	mutex         sync.Mutex
+	notFullCond   *sync.Cond
+	queues        []*list.List
+	length        int
+	offering      *list.Element
+	closed        bool
+	notifyCh      chan struct{}
+	config        TaskEventChannelConfig
+	agingInterval time.Duration
 }
 
 func NewTaskEventChannel() *TaskEventChannel {
+	return NewTaskEventChannelWithConfig(TaskEventChannelConfig{})
+}
+
+func NewTaskEventChannelWithConfig(config TaskEventChannelConfig) *TaskEventChannel {
+	if config.Clock == nil {
+		config.Clock = timeutils.NewRealClock()
+	}
 	eventCh := &TaskEventChannel{
-		SendCh: make(chan TaskItemId, 1),
-		RecvCh: make(chan TaskItemId, 1),
-		queue:  list.New(),
+		RecvCh:        make(chan TaskEvent),
+		queues:        make([]*list.List, taskPriorityLevels),
+		notifyCh:      make(chan struct{}, 1),
+		config:        config,
+		agingInterval: time.Duration(config.AgingIntervalInMs) * time.Millisecond,
+	}
+	eventCh.notFullCond = sync.NewCond(&eventCh.mutex)
+	for i := range eventCh.queues {
+		eventCh.queues[i] = list.New()
 	}
 	go eventCh.handleChannel()
 	return eventCh
 }
 
+// Send buffers event according to the overflow policy, it returns
+// ErrTaskRunnerShutdown once the channel is closed.
+func (m *TaskEventChannel) Send(event TaskEvent) error {
+	return m.send(event, m.config.OverflowPolicy)
+}
+
+// TrySend is the non-blocking variant of Send, it returns ErrTaskQueueFull
+// instead of blocking with OverflowBlock.
+func (m *TaskEventChannel) TrySend(event TaskEvent) error {
+	policy := m.config.OverflowPolicy
+	if policy == OverflowBlock {
+		policy = OverflowReject
+	}
+	return m.send(event, policy)
+}
+
+func (m *TaskEventChannel) send(event TaskEvent, policy OverflowPolicy) error {
+	var dropped *TaskEvent
+	m.mutex.Lock()
+	for !m.closed && m.isFull() {
+		if policy == OverflowBlock {
+			m.notFullCond.Wait()
+			continue
+		}
+		if policy == OverflowDropOldest {
+			if dropped = m.removeOldest(); dropped != nil {
+				break
+			}
+		}
+		m.mutex.Unlock()
+		if policy == OverflowDropNewest {
+			return ErrTaskDropped
+		}
+		return ErrTaskQueueFull
+	}
+	if m.closed {
+		m.mutex.Unlock()
+		return ErrTaskRunnerShutdown
+	}
+	m.queues[event.Priority.level()].PushBack(event)
+	m.length++
+	m.mutex.Unlock()
+	m.notify()
+	if dropped != nil && m.config.DropHandler != nil {
+		m.config.DropHandler(*dropped)
+	}
+	return nil
+}
+
+// Close stops accepting events, the buffered events are still delivered
+// before RecvCh gets closed.
+func (m *TaskEventChannel) Close() {
+	m.mutex.Lock()
+	m.closed = true
+	m.notFullCond.Broadcast()
+	m.mutex.Unlock()
+	m.notify()
+}
+
+// Len returns the number of events buffered and not yet received.
+func (m *TaskEventChannel) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.length
+}
+
+func (m *TaskEventChannel) isFull() bool {
+	return m.config.Capacity > 0 && m.length >= m.config.Capacity
+}
+
+// removeOldest evicts the front of the least urgent non-empty priority, the
+// event currently offered on RecvCh is never evicted.
+func (m *TaskEventChannel) removeOldest() *TaskEvent {
+	for level := len(m.queues) - 1; level >= 0; level-- {
+		for element := m.queues[level].Front(); element != nil; element = element.Next() {
+			if element == m.offering {
+				continue
+			}
+			event := m.queues[level].Remove(element).(TaskEvent)
+			m.length--
+			return &event
+		}
+	}
+	return nil
+}
+
+func (m *TaskEventChannel) notify() {
+	select {
+	case m.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// handleChannel offers the most urgent event on RecvCh and re-evaluates the
+// choice whenever an event arrives or, with aging, every aging interval.
 func (m *TaskEventChannel) handleChannel() {
+	var agingCh <-chan time.Time
+	if m.agingInterval > 0 {
+		ticker := m.config.Clock.NewTicker(m.agingInterval)
+		defer ticker.Stop()
+		agingCh = ticker.C()
+	}
 	for {
-		if front := m.queue.Front(); front == nil {
-			if m.SendCh == nil {
+		m.mutex.Lock()
+		front := m.front(m.config.Clock.Now())
+		if front == nil {
+			closed := m.closed
+			m.mutex.Unlock()
+			if closed {
 				close(m.RecvCh)
 				return
 			}
-			value, ok := <-m.SendCh
-			if !ok {
-				close(m.RecvCh)
-				return
-			}
-			m.queue.PushBack(value)
-		} else {
-			select {
-			case m.RecvCh <- front.Value.(TaskItemId):
-				m.queue.Remove(front)
-			case value, ok := <-m.SendCh:
-				if ok {
-					m.queue.PushBack(value)
-				} else {
-					m.RecvCh = nil
-				}
-			}
+			<-m.notifyCh
+			continue
+		}
+		m.offering = front
+		m.mutex.Unlock()
+
+		event := front.Value.(TaskEvent)
+		select {
+		case m.RecvCh <- event:
+			m.mutex.Lock()
+			m.queues[event.Priority.level()].Remove(front)
+			m.length--
+			m.offering = nil
+			m.notFullCond.Signal()
+			m.mutex.Unlock()
+		case <-m.notifyCh:
+		case <-agingCh:
+			// re-evaluate the effective priorities
+		}
+	}
+}
+
+// front returns the element which should be delivered next.
+func (m *TaskEventChannel) front(now time.Time) *list.Element {
+	var selected *list.Element
+	var selectedLevel int
+	for level, queue := range m.queues {
+		front := queue.Front()
+		if front == nil {
+			continue
 		}
+		effectiveLevel := m.effectiveLevel(level, front.Value.(TaskEvent), now)
+		if selected == nil || effectiveLevel < selectedLevel ||
+			(effectiveLevel == selectedLevel && front.Value.(TaskEvent).EnqueueTime.Before(selected.Value.(TaskEvent).EnqueueTime)) {
+			selected = front
+			selectedLevel = effectiveLevel
+		}
+	}
+	return selected
+}
+
+func (m *TaskEventChannel) effectiveLevel(level int, event TaskEvent, now time.Time) int {
+	if m.agingInterval <= 0 {
+		return level
+	}
+	level -= int(now.Sub(event.EnqueueTime) / m.agingInterval)
+	if level < 0 {
+		return 0
 	}
+	return level
 }
@@ -0,0 +1,213 @@
+package taskrunner
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	fileTaskStoreOpAdd  = "add"
+	fileTaskStoreOpDone = "done"
+
+	// the log is rewritten once it holds this many completed records more
+	// than pending ones
+	fileTaskStoreCompactThreshold = 1024
+)
+
+type fileTaskStoreEntry struct {
+	Op     string      `json:"op"`
+	Seq    uint64      `json:"seq"`
+	Record *TaskRecord `json:"record,omitempty"`
+}
+
+// FileTaskStore is a TaskStore backed by a local write-ahead log, one JSON
+// entry per line. Every write is fsynced before it returns, and the log is
+// compacted on open and whenever enough records have completed.
+type FileTaskStore struct {
+	mutex     sync.Mutex
+	filePath  string
+	file      *os.File
+	nextSeq   uint64
+	pending   map[uint64]*TaskRecord
+	completed int
+}
+
+func NewFileTaskStore(filePath string) (*FileTaskStore, error) {
+	store := &FileTaskStore{
+		filePath: filePath,
+		pending:  make(map[uint64]*TaskRecord, 0),
+	}
+	if err := store.replay(); err != nil {
+		return nil, err
+	}
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (m *FileTaskStore) replay() error {
+	file, err := os.Open(m.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			entry := &fileTaskStoreEntry{}
+			if jsonErr := json.Unmarshal(line, entry); jsonErr != nil {
+				// a torn write at the tail of the log, the entry was never acknowledged
+				klog.Warningf("SkipCorruptedTaskStoreEntry File:%s Error:%v", m.filePath, jsonErr)
+			} else {
+				m.apply(entry)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (m *FileTaskStore) apply(entry *fileTaskStoreEntry) {
+	if entry.Seq >= m.nextSeq {
+		m.nextSeq = entry.Seq + 1
+	}
+	switch entry.Op {
+	case fileTaskStoreOpAdd:
+		if entry.Record != nil {
+			m.pending[entry.Seq] = entry.Record
+		}
+	case fileTaskStoreOpDone:
+		delete(m.pending, entry.Seq)
+	}
+}
+
+// compact rewrites the log with the pending records only, the new log is
+// fsynced and renamed over the old one so a crash leaves either of them.
+func (m *FileTaskStore) compact() error {
+	tmpPath := m.filePath + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	for _, record := range m.sortedRecords() {
+		if err = m.writeEntry(writer, &fileTaskStoreEntry{Op: fileTaskStoreOpAdd, Seq: record.Seq, Record: record}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, m.filePath); err != nil {
+		return err
+	}
+	if m.file != nil {
+		_ = m.file.Close()
+	}
+	m.file, err = os.OpenFile(m.filePath, os.O_APPEND|os.O_WRONLY, 0644)
+	m.completed = 0
+	return err
+}
+
+func (m *FileTaskStore) writeEntry(writer io.Writer, entry *fileTaskStoreEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(append(line, '\n'))
+	return err
+}
+
+func (m *FileTaskStore) appendEntry(entry *fileTaskStoreEntry) error {
+	if m.file == nil {
+		return os.ErrClosed
+	}
+	if err := m.writeEntry(m.file, entry); err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
+
+func (m *FileTaskStore) Append(record *TaskRecord) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	record.Seq = m.nextSeq
+	if err := m.appendEntry(&fileTaskStoreEntry{Op: fileTaskStoreOpAdd, Seq: record.Seq, Record: record}); err != nil {
+		return err
+	}
+	m.nextSeq++
+	m.pending[record.Seq] = record
+	return nil
+}
+
+func (m *FileTaskStore) Complete(seq uint64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, found := m.pending[seq]; !found {
+		return nil
+	}
+	if err := m.appendEntry(&fileTaskStoreEntry{Op: fileTaskStoreOpDone, Seq: seq}); err != nil {
+		return err
+	}
+	delete(m.pending, seq)
+	m.completed++
+	if m.completed >= fileTaskStoreCompactThreshold && m.completed > len(m.pending) {
+		if err := m.compact(); err != nil {
+			klog.Errorf("CompactTaskStoreFailed File:%s Error:%v", m.filePath, err)
+		}
+	}
+	return nil
+}
+
+func (m *FileTaskStore) Load() ([]*TaskRecord, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.sortedRecords(), nil
+}
+
+func (m *FileTaskStore) sortedRecords() []*TaskRecord {
+	records := make([]*TaskRecord, 0, len(m.pending))
+	for _, record := range m.pending {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+	return records
+}
+
+func (m *FileTaskStore) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
@@ -0,0 +1,96 @@
+package taskrunner
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// TaskPanicError is reported by TaskFuture when the closure panicked, it is
+// also passed to the PanicHandler of the runner.
+type TaskPanicError struct {
+	Id     TaskItemId
+	Runner string
+	// Closure is the function name of a func closure or the type of the others.
+	Closure string
+	Value   interface{}
+	Stack   []byte
+}
+
+func (e *TaskPanicError) Error() string {
+	return fmt.Sprintf("task %d (%s) panicked: %v", e.Id, e.Closure, e.Value)
+}
+
+// TaskFuture is the handle of a task submitted through TaskRunner.SubmitTask,
+// it is completed exactly once when the task finished, was skipped because its
+// ctx was done or was dropped by Shutdown.
+type TaskFuture struct {
+	id       TaskItemId
+	doneCh   chan struct{}
+	result   interface{}
+	err      error
+	attempts int32
+}
+
+func newTaskFuture(id TaskItemId) *TaskFuture {
+	return &TaskFuture{
+		id:     id,
+		doneCh: make(chan struct{}),
+	}
+}
+
+func newCompletedTaskFuture(id TaskItemId, err error) *TaskFuture {
+	future := newTaskFuture(id)
+	future.complete(nil, err)
+	return future
+}
+
+func (m *TaskFuture) complete(result interface{}, err error) {
+	m.result = result
+	m.err = err
+	close(m.doneCh)
+}
+
+// Id returns the id of the task, it is 0 if the task was rejected.
+func (m *TaskFuture) Id() TaskItemId {
+	return m.id
+}
+
+// Attempts returns how many times the closure has been started so far.
+func (m *TaskFuture) Attempts() int {
+	return int(atomic.LoadInt32(&m.attempts))
+}
+
+// Done returns a channel which is closed once the task is completed.
+func (m *TaskFuture) Done() <-chan struct{} {
+	return m.doneCh
+}
+
+// Wait blocks until the task is completed.
+func (m *TaskFuture) Wait() (interface{}, error) {
+	<-m.doneCh
+	return m.result, m.err
+}
+
+// WaitContext blocks until the task is completed or ctx is done, in the latter
+// case ctx.Err() is returned and the task keeps going.
+func (m *TaskFuture) WaitContext(ctx context.Context) (interface{}, error) {
+	select {
+	case <-m.doneCh:
+		return m.result, m.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Result blocks until the task is completed and returns its result.
+func (m *TaskFuture) Result() interface{} {
+	<-m.doneCh
+	return m.result
+}
+
+// Err blocks until the task is completed and returns its error.
+func (m *TaskFuture) Err() error {
+	<-m.doneCh
+	return m.err
+}
@@ -0,0 +1,245 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrTaskNodeSkipped = errors.New("task node skipped because a dependency did not succeed")
+)
+
+type TaskNodeState int
+
+const (
+	TaskNodeSucceeded TaskNodeState = iota
+	TaskNodeFailed
+	TaskNodeSkipped
+)
+
+func (s TaskNodeState) String() string {
+	switch s {
+	case TaskNodeSucceeded:
+		return "succeeded"
+	case TaskNodeFailed:
+		return "failed"
+	case TaskNodeSkipped:
+		return "skipped"
+	default:
+		return fmt.Sprintf("TaskNodeState(%d)", int(s))
+	}
+}
+
+type TaskNodeResult struct {
+	Name   string
+	State  TaskNodeState
+	Result interface{}
+	Err    error
+}
+
+type TaskGraphResult struct {
+	Nodes map[string]*TaskNodeResult
+	// Err is the first node failure, or the validation error of the graph.
+	Err error
+}
+
+func (m *TaskGraphResult) Succeeded() bool {
+	return m.Err == nil
+}
+
+// TaskGraph runs a set of named closures on a TaskRunner honoring their
+// dependencies: a node is submitted once all its dependencies succeeded,
+// independent nodes run concurrently, and the descendants of a failed node
+// are skipped while the unrelated branches keep going.
+type TaskGraph struct {
+	taskRunner *TaskRunner
+	nodes      map[string]*taskGraphNode
+	names      []string
+}
+
+type taskGraphNode struct {
+	name       string
+	closure    TaskResultClosure
+	deps       []string
+	opts       []TaskOption
+	dependents []string
+}
+
+func NewTaskGraph(taskRunner *TaskRunner) *TaskGraph {
+	return &TaskGraph{
+		taskRunner: taskRunner,
+		nodes:      make(map[string]*taskGraphNode, 0),
+	}
+}
+
+// AddNode adds closure named name which runs after all nodes in deps
+// succeeded. The dependencies may be added later, they are checked by Run.
+func (m *TaskGraph) AddNode(name string, closure TaskResultClosure, deps []string, opts ...TaskOption) error {
+	if _, found := m.nodes[name]; found {
+		return fmt.Errorf("TaskGraph: duplicated node %q", name)
+	}
+	m.nodes[name] = &taskGraphNode{
+		name:    name,
+		closure: closure,
+		deps:    append([]string(nil), deps...),
+		opts:    opts,
+	}
+	m.names = append(m.names, name)
+	return nil
+}
+
+func (m *TaskGraph) validate() error {
+	for _, node := range m.nodes {
+		node.dependents = nil
+	}
+	for _, name := range m.names {
+		for _, dep := range m.nodes[name].deps {
+			depNode, found := m.nodes[dep]
+			if !found {
+				return fmt.Errorf("TaskGraph: node %q depends on unknown node %q", name, dep)
+			}
+			depNode.dependents = append(depNode.dependents, name)
+		}
+	}
+	// Kahn's algorithm, whatever is left over is part of a cycle
+	remaining := make(map[string]int, len(m.nodes))
+	var ready []string
+	for _, name := range m.names {
+		remaining[name] = len(m.nodes[name].deps)
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		visited++
+		for _, dependent := range m.nodes[name].dependents {
+			if remaining[dependent]--; remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if visited != len(m.nodes) {
+		var cycle []string
+		for name, count := range remaining {
+			if count > 0 {
+				cycle = append(cycle, name)
+			}
+		}
+		sort.Strings(cycle)
+		return fmt.Errorf("TaskGraph: cycle between nodes %v", cycle)
+	}
+	return nil
+}
+
+type taskGraphInputsKey struct{}
+
+// TaskNodeInput returns the result of the dependency dep of the node running
+// with ctx.
+func TaskNodeInput(ctx context.Context, dep string) (interface{}, bool) {
+	inputs, _ := ctx.Value(taskGraphInputsKey{}).(map[string]interface{})
+	result, found := inputs[dep]
+	return result, found
+}
+
+type taskNodeCompletion struct {
+	name   string
+	result interface{}
+	err    error
+}
+
+// Run executes the graph and blocks until every node has completed or been
+// skipped. Nodes not yet submitted when ctx is done are skipped.
+func (m *TaskGraph) Run(ctx context.Context) *TaskGraphResult {
+	graphResult := &TaskGraphResult{
+		Nodes: make(map[string]*TaskNodeResult, len(m.nodes)),
+	}
+	if err := m.validate(); err != nil {
+		graphResult.Err = err
+		return graphResult
+	}
+
+	remaining := make(map[string]int, len(m.nodes))
+	for _, name := range m.names {
+		remaining[name] = len(m.nodes[name].deps)
+	}
+	completionCh := make(chan taskNodeCompletion, len(m.nodes))
+	pending := 0
+
+	var skip func(name string, err error)
+	skip = func(name string, err error) {
+		if _, done := graphResult.Nodes[name]; done {
+			return
+		}
+		graphResult.Nodes[name] = &TaskNodeResult{Name: name, State: TaskNodeSkipped, Err: err}
+		for _, dependent := range m.nodes[name].dependents {
+			skip(dependent, ErrTaskNodeSkipped)
+		}
+	}
+	submit := func(name string) {
+		if err := ctx.Err(); err != nil {
+			skip(name, err)
+			return
+		}
+		node := m.nodes[name]
+		inputs := make(map[string]interface{}, len(node.deps))
+		for _, dep := range node.deps {
+			inputs[dep] = graphResult.Nodes[dep].Result
+		}
+		future := m.taskRunner.SubmitTask(context.WithValue(ctx, taskGraphInputsKey{}, inputs), node.closure, node.opts...)
+		pending++
+		go func() {
+			result, err := future.Wait()
+			completionCh <- taskNodeCompletion{name: name, result: result, err: err}
+		}()
+	}
+
+	for _, name := range m.names {
+		if remaining[name] == 0 {
+			submit(name)
+		}
+	}
+	for pending > 0 {
+		completion := <-completionCh
+		pending--
+		if completion.err != nil {
+			graphResult.Nodes[completion.name] = &TaskNodeResult{
+				Name:  completion.name,
+				State: TaskNodeFailed,
+				Err:   completion.err,
+			}
+			if graphResult.Err == nil {
+				graphResult.Err = fmt.Errorf("TaskGraph: node %q failed: %w", completion.name, completion.err)
+			}
+			for _, dependent := range m.nodes[completion.name].dependents {
+				skip(dependent, ErrTaskNodeSkipped)
+			}
+			continue
+		}
+		graphResult.Nodes[completion.name] = &TaskNodeResult{
+			Name:   completion.name,
+			State:  TaskNodeSucceeded,
+			Result: completion.result,
+		}
+		for _, dependent := range m.nodes[completion.name].dependents {
+			if remaining[dependent]--; remaining[dependent] == 0 {
+				if _, done := graphResult.Nodes[dependent]; !done {
+					submit(dependent)
+				}
+			}
+		}
+	}
+	if graphResult.Err == nil && ctx.Err() != nil {
+		for _, name := range m.names {
+			if graphResult.Nodes[name].State == TaskNodeSkipped {
+				graphResult.Err = ctx.Err()
+				break
+			}
+		}
+	}
+	return graphResult
+}
@@ -0,0 +1,161 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func graphNode(fn func(ctx context.Context) (interface{}, error)) TaskResultClosure {
+	return TaskResultFunc(fn)
+}
+
+func graphInput(ctx context.Context, dep string) int {
+	input, _ := TaskNodeInput(ctx, dep)
+	return input.(int)
+}
+
+func TestTaskGraphDependencies(t *testing.T) {
+	runner := NewTaskRunner("graph", 4)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	graph := NewTaskGraph(runner)
+	// d depends on b and c which both depend on a, added in reverse order
+	graph.AddNode("d", graphNode(func(ctx context.Context) (interface{}, error) {
+		return graphInput(ctx, "b") + graphInput(ctx, "c"), nil
+	}), []string{"b", "c"})
+	graph.AddNode("b", graphNode(func(ctx context.Context) (interface{}, error) {
+		return graphInput(ctx, "a") + 1, nil
+	}), []string{"a"})
+	graph.AddNode("c", graphNode(func(ctx context.Context) (interface{}, error) {
+		return graphInput(ctx, "a") * 10, nil
+	}), []string{"a"})
+	graph.AddNode("a", graphNode(func(ctx context.Context) (interface{}, error) {
+		return 1, nil
+	}), nil)
+	if err := graph.AddNode("a", graphNode(nil), nil); err == nil {
+		t.Fatal("duplicated node added")
+	}
+
+	result := graph.Run(context.Background())
+	if !result.Succeeded() {
+		t.Fatal(result.Err)
+	}
+	if len(result.Nodes) != 4 {
+		t.Fatalf("%d node results", len(result.Nodes))
+	}
+	for name, want := range map[string]int{"a": 1, "b": 2, "c": 10, "d": 12} {
+		node := result.Nodes[name]
+		if node.State != TaskNodeSucceeded || node.Result != want {
+			t.Errorf("node %s: %v %v, want %d", name, node.State, node.Result, want)
+		}
+	}
+}
+
+func TestTaskGraphValidation(t *testing.T) {
+	runner := NewTaskRunner("graph", 2)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	var runs int32
+	closure := graphNode(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, nil
+	})
+
+	unknown := NewTaskGraph(runner)
+	unknown.AddNode("a", closure, []string{"missing"})
+	if result := unknown.Run(context.Background()); result.Err == nil || !strings.Contains(result.Err.Error(), `unknown node "missing"`) {
+		t.Fatalf("unknown dependency: %v", result.Err)
+	}
+
+	cycle := NewTaskGraph(runner)
+	cycle.AddNode("a", closure, []string{"b"})
+	cycle.AddNode("b", closure, []string{"a"})
+	cycle.AddNode("c", closure, nil)
+	cycle.AddNode("d", closure, []string{"c"})
+	if result := cycle.Run(context.Background()); result.Err == nil || !strings.Contains(result.Err.Error(), "cycle between nodes [a b]") {
+		t.Fatalf("cycle: %v", result.Err)
+	}
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatalf("%d nodes of an invalid graph ran", n)
+	}
+}
+
+func TestTaskGraphSkipCascade(t *testing.T) {
+	runner := NewTaskRunner("graph", 4)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	errFailed := errors.New("failed")
+	var runs int32
+	succeed := graphNode(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, nil
+	})
+	graph := NewTaskGraph(runner)
+	graph.AddNode("a", graphNode(func(ctx context.Context) (interface{}, error) {
+		return nil, errFailed
+	}), nil)
+	graph.AddNode("b", succeed, []string{"a"})
+	graph.AddNode("c", succeed, []string{"b"})
+	// e also depends on the independent branch
+	graph.AddNode("d", succeed, nil)
+	graph.AddNode("e", succeed, []string{"c", "d"})
+	graph.AddNode("f", succeed, []string{"d"})
+
+	result := graph.Run(context.Background())
+	if !errors.Is(result.Err, errFailed) || !strings.Contains(result.Err.Error(), `node "a"`) {
+		t.Fatalf("graph error %v", result.Err)
+	}
+	states := map[string]TaskNodeState{
+		"a": TaskNodeFailed,
+		"b": TaskNodeSkipped,
+		"c": TaskNodeSkipped,
+		"d": TaskNodeSucceeded,
+		"e": TaskNodeSkipped,
+		"f": TaskNodeSucceeded,
+	}
+	for name, state := range states {
+		if node := result.Nodes[name]; node.State != state {
+			t.Errorf("node %s %v, want %v", name, node.State, state)
+		} else if state == TaskNodeSkipped && !errors.Is(node.Err, ErrTaskNodeSkipped) {
+			t.Errorf("node %s error %v", name, node.Err)
+		}
+	}
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Fatalf("%d nodes ran, want 2", n)
+	}
+}
+
+func TestTaskGraphContextCancel(t *testing.T) {
+	runner := NewTaskRunner("graph", 2)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var runs int32
+	graph := NewTaskGraph(runner)
+	graph.AddNode("a", graphNode(func(ctx context.Context) (interface{}, error) {
+		cancel()
+		return nil, nil
+	}), nil)
+	graph.AddNode("b", graphNode(func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&runs, 1)
+		return nil, nil
+	}), []string{"a"})
+
+	result := graph.Run(ctx)
+	if !errors.Is(result.Err, context.Canceled) {
+		t.Fatalf("graph error %v", result.Err)
+	}
+	if node := result.Nodes["a"]; node.State != TaskNodeSucceeded {
+		t.Fatalf("node a %v", node.State)
+	}
+	if node := result.Nodes["b"]; node.State != TaskNodeSkipped || !errors.Is(node.Err, context.Canceled) {
+		t.Fatalf("node b %v %v", node.State, node.Err)
+	}
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatal("node submitted after the ctx was cancelled")
+	}
+}
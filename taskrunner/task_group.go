@@ -0,0 +1,129 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+type taskGroupOptions struct {
+	limit           int
+	continueOnError bool
+}
+
+type TaskGroupOption func(options *taskGroupOptions)
+
+// WithGroupLimit bounds the number of tasks of the group queued or running at
+// the same time, TaskGroup.Go blocks until one of them completes.
+func WithGroupLimit(limit int) TaskGroupOption {
+	return func(options *taskGroupOptions) {
+		options.limit = limit
+	}
+}
+
+// WithGroupContinueOnError keeps the other tasks going when one fails, all the
+// errors are then available through TaskGroup.Errors.
+func WithGroupContinueOnError() TaskGroupOption {
+	return func(options *taskGroupOptions) {
+		options.continueOnError = true
+	}
+}
+
+// TaskGroup runs a set of tasks on a TaskRunner and waits for all of them, like
+// errgroup.Group. By default the first failure cancels the ctx of the group, so
+// the tasks still queued are skipped and the running ones should return early.
+type TaskGroup struct {
+	taskRunner *TaskRunner
+	ctx        context.Context
+	cancel     context.CancelFunc
+	options    taskGroupOptions
+	semaphore  chan struct{}
+	wg         sync.WaitGroup
+	mutex      sync.Mutex
+	errs       []error
+}
+
+// NewTaskGroup returns a group whose tasks run with a ctx derived from ctx, the
+// derived ctx is also returned and is cancelled by the first failure or once
+// Wait returns.
+func (m *TaskRunner) NewTaskGroup(ctx context.Context, opts ...TaskGroupOption) (*TaskGroup, context.Context) {
+	group := &TaskGroup{
+		taskRunner: m,
+	}
+	for _, opt := range opts {
+		opt(&group.options)
+	}
+	if group.options.limit > 0 {
+		group.semaphore = make(chan struct{}, group.options.limit)
+	}
+	group.ctx, group.cancel = context.WithCancel(ctx)
+	return group, group.ctx
+}
+
+// Go submits closure to the runner, blocking while the group is at its limit.
+// A panic of closure is reported as *TaskPanicError.
+func (m *TaskGroup) Go(closure func(ctx context.Context) error, opts ...TaskOption) {
+	if m.semaphore != nil {
+		m.semaphore <- struct{}{}
+	}
+	m.submit(closure, opts)
+}
+
+// TryGo is the non-blocking variant of Go, it returns false without
+// submitting closure when the group is at its limit.
+func (m *TaskGroup) TryGo(closure func(ctx context.Context) error, opts ...TaskOption) bool {
+	if m.semaphore != nil {
+		select {
+		case m.semaphore <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	m.submit(closure, opts)
+	return true
+}
+
+func (m *TaskGroup) submit(closure func(ctx context.Context) error, opts []TaskOption) {
+	m.wg.Add(1)
+	m.taskRunner.addFuncTask(m.ctx, closure, opts, m.done)
+}
+
+func (m *TaskGroup) done(err error) {
+	if err != nil {
+		m.mutex.Lock()
+		// the tasks skipped or interrupted by the cancellation of the group
+		// only repeat the first failure
+		cancelled := len(m.errs) > 0 && !m.options.continueOnError && errors.Is(err, context.Canceled)
+		if !cancelled {
+			m.errs = append(m.errs, err)
+		}
+		m.mutex.Unlock()
+		if !m.options.continueOnError {
+			m.cancel()
+		}
+	}
+	if m.semaphore != nil {
+		<-m.semaphore
+	}
+	m.wg.Done()
+}
+
+// Wait blocks until all the tasks submitted by Go are completed and returns
+// the first error.
+func (m *TaskGroup) Wait() error {
+	m.wg.Wait()
+	m.cancel()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if len(m.errs) == 0 {
+		return nil
+	}
+	return m.errs[0]
+}
+
+// Errors returns the errors of the completed tasks in completion order.
+func (m *TaskGroup) Errors() []error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return append([]error(nil), m.errs...)
+}
@@ -0,0 +1,143 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTaskGroupFirstError(t *testing.T) {
+	runner := NewTaskRunner("group", 4)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	errFailed := errors.New("failed")
+	group, groupCtx := runner.NewTaskGroup(context.Background())
+	startedCh := make(chan struct{})
+	group.Go(func(ctx context.Context) error {
+		close(startedCh)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	<-startedCh
+	group.Go(func(ctx context.Context) error {
+		return errFailed
+	})
+	if err := group.Wait(); !errors.Is(err, errFailed) {
+		t.Fatal(err)
+	}
+	// the cancellation of the running task is not reported again
+	if errs := group.Errors(); len(errs) != 1 {
+		t.Fatalf("errors %v", errs)
+	}
+	if groupCtx.Err() == nil {
+		t.Fatal("group ctx not cancelled")
+	}
+}
+
+func TestTaskGroupContinueOnError(t *testing.T) {
+	runner := NewTaskRunner("group", 4)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	group, _ := runner.NewTaskGroup(context.Background(), WithGroupContinueOnError())
+	var succeeded int32
+	for i := 0; i < 4; i++ {
+		fail := i%2 == 0
+		group.Go(func(ctx context.Context) error {
+			if fail {
+				return errors.New("failed")
+			}
+			time.Sleep(10 * time.Millisecond)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			atomic.AddInt32(&succeeded, 1)
+			return nil
+		})
+	}
+	if err := group.Wait(); err == nil {
+		t.Fatal("no error")
+	}
+	if errs := group.Errors(); len(errs) != 2 {
+		t.Fatalf("errors %v", errs)
+	}
+	if n := atomic.LoadInt32(&succeeded); n != 2 {
+		t.Fatalf("%d tasks succeeded, want 2", n)
+	}
+}
+
+func TestTaskGroupLimit(t *testing.T) {
+	runner := NewTaskRunner("group", 8)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	group, _ := runner.NewTaskGroup(context.Background(), WithGroupLimit(2))
+	var running, maxRunning int32
+	for i := 0; i < 10; i++ {
+		group.Go(func(ctx context.Context) error {
+			current := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if current <= max || atomic.CompareAndSwapInt32(&maxRunning, max, current) {
+					break
+				}
+			}
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if max := atomic.LoadInt32(&maxRunning); max > 2 {
+		t.Fatalf("%d tasks ran at the same time, limit 2", max)
+	}
+
+	releaseCh := make(chan struct{})
+	group, _ = runner.NewTaskGroup(context.Background(), WithGroupLimit(2))
+	for i := 0; i < 2; i++ {
+		if !group.TryGo(func(ctx context.Context) error {
+			<-releaseCh
+			return nil
+		}) {
+			t.Fatal("TryGo rejected below the limit")
+		}
+	}
+	if group.TryGo(func(ctx context.Context) error {
+		return nil
+	}) {
+		t.Fatal("TryGo accepted at the limit")
+	}
+	close(releaseCh)
+	if err := group.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskGroupPanic(t *testing.T) {
+	runner := NewTaskRunner("group", 2, WithPanicHandler(func(err *TaskPanicError) {}))
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	group, _ := runner.NewTaskGroup(context.Background())
+	group.Go(func(ctx context.Context) error {
+		panic("boom")
+	})
+	var panicErr *TaskPanicError
+	if err := group.Wait(); !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Fatal(err)
+	}
+}
+
+func TestTaskGroupRejected(t *testing.T) {
+	runner := NewTaskRunner("group", 2)
+	runner.Startup()
+	runner.Shutdown(context.Background(), false)
+	group, _ := runner.NewTaskGroup(context.Background())
+	group.Go(func(ctx context.Context) error {
+		return nil
+	})
+	if err := group.Wait(); !errors.Is(err, ErrTaskRunnerShutdown) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,251 @@
+package taskrunner
+
+import (
+	"container/list"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type TaskKind int
+
+const (
+	TaskKindOneShot TaskKind = iota
+	TaskKindRepeating
+	TaskKindDelayed
+	TaskKindCron
+)
+
+func (k TaskKind) String() string {
+	switch k {
+	case TaskKindOneShot:
+		return "OneShot"
+	case TaskKindRepeating:
+		return "Repeating"
+	case TaskKindDelayed:
+		return "Delayed"
+	case TaskKindCron:
+		return "Cron"
+	default:
+		return "Unknown"
+	}
+}
+
+type TaskState int
+
+const (
+	// TaskStateQueued tasks wait for a worker, including tasks in retry
+	// backoff and tasks parked by a serial key, dedup or rate limit.
+	TaskStateQueued TaskState = iota
+	TaskStateRunning
+	// TaskStateScheduled are repeating, delayed and cron tasks waiting for
+	// their next fire time.
+	TaskStateScheduled
+	TaskStateDone
+)
+
+func (s TaskState) String() string {
+	switch s {
+	case TaskStateQueued:
+		return "Queued"
+	case TaskStateRunning:
+		return "Running"
+	case TaskStateScheduled:
+		return "Scheduled"
+	case TaskStateDone:
+		return "Done"
+	default:
+		return "Unknown"
+	}
+}
+
+type TaskInfo struct {
+	Id         TaskItemId
+	Kind       TaskKind
+	State      TaskState
+	SubmitTime time.Time
+	// NextFireTime is zero for one-shot tasks and finished scheduled tasks.
+	NextFireTime time.Time
+	// RunCount is the number of runs started, retries included.
+	RunCount int
+	// Paused is set for a scheduled task paused by PauseTask.
+	Paused bool
+	// SkippedRuns counts the fires of a repeating task dropped because the
+	// previous run was still queued or running.
+	SkippedRuns int
+}
+
+// WithTaskHistory keeps the TaskInfo of the last size finished tasks for
+// GetTask and ListTasks, 128 by default. 0 disables the history.
+func WithTaskHistory(size int) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.taskHistorySize = size
+	}
+}
+
+// describe returns the TaskInfo of a task created with these options, the
+// state is left to the caller.
+func (m *taskOptions) describe(id TaskItemId) *TaskInfo {
+	info := &TaskInfo{
+		Id:         id,
+		Kind:       m.kind,
+		SubmitTime: m.submitTime,
+	}
+	if m.runCount != nil {
+		info.RunCount = int(atomic.LoadInt32(m.runCount))
+	}
+	return info
+}
+
+// scheduled sets the fields shared by the runs of a repeating, delayed or
+// cron task before its options are used by runOptions.
+func (m *taskOptions) scheduled(kind TaskKind, now time.Time) {
+	m.kind = kind
+	m.submitTime = now
+	m.runCount = new(int32)
+}
+
+func (m *taskItem) info() *TaskInfo {
+	info := &TaskInfo{
+		Id:         m.id,
+		Kind:       m.kind,
+		State:      TaskStateQueued,
+		SubmitTime: m.submitTime,
+		RunCount:   int(atomic.LoadInt32(&m.future.attempts)),
+	}
+	if m.runCount != nil {
+		info.RunCount = int(atomic.LoadInt32(m.runCount))
+	}
+	if m.isRunning {
+		info.State = TaskStateRunning
+	}
+	return info
+}
+
+// taskHistory keeps the last finished tasks, guarded by the runner mutex.
+type taskHistory struct {
+	size  int
+	infos map[TaskItemId]*list.Element
+	order *list.List
+}
+
+func newTaskHistory(size int) *taskHistory {
+	return &taskHistory{
+		size:  size,
+		infos: make(map[TaskItemId]*list.Element, 0),
+		order: list.New(),
+	}
+}
+
+func (m *taskHistory) add(info *TaskInfo) {
+	if m.size <= 0 {
+		return
+	}
+	info.State = TaskStateDone
+	info.NextFireTime = time.Time{}
+	if element, found := m.infos[info.Id]; found {
+		m.order.Remove(element)
+	}
+	m.infos[info.Id] = m.order.PushBack(info)
+	for m.order.Len() > m.size {
+		oldest := m.order.Remove(m.order.Front()).(*TaskInfo)
+		delete(m.infos, oldest.Id)
+	}
+}
+
+func (m *taskHistory) get(id TaskItemId) (*TaskInfo, bool) {
+	element, found := m.infos[id]
+	if !found {
+		return nil, false
+	}
+	info := *element.Value.(*TaskInfo)
+	return &info, true
+}
+
+// taskFinishedLocked records a finished run, a run of a repeating or cron
+// task which is still scheduled does not finish the task. m.mutex must be held.
+func (m *TaskRunner) taskFinishedLocked(task *taskItem) {
+	if _, found := m.customTaskMap[task.id]; found {
+		return
+	}
+	if _, found := m.taskMap[task.id]; found {
+		return
+	}
+	m.history.add(task.info())
+}
+
+// customTaskRemovedLocked records a scheduled task removed while none of its
+// runs is in flight. m.mutex must be held.
+func (m *TaskRunner) customTaskRemovedLocked(id TaskItemId, task customTaskItem) {
+	if _, found := m.taskMap[id]; found {
+		return
+	}
+	m.history.add(task.info())
+}
+
+func (m *TaskRunner) getTaskInfoLocked(id TaskItemId) (*TaskInfo, bool) {
+	customTask, customFound := m.customTaskMap[id]
+	if task, found := m.taskMap[id]; found {
+		info := task.info()
+		if customFound {
+			// the scheduled task with the state of its current run
+			scheduledInfo := customTask.info()
+			scheduledInfo.State = info.State
+			return scheduledInfo, true
+		}
+		return info, true
+	}
+	if customFound {
+		info := customTask.info()
+		info.State = TaskStateScheduled
+		return info, true
+	}
+	return m.history.get(id)
+}
+
+// GetTask returns what the runner knows about task id, finished tasks are
+// only found while they are kept by the history.
+func (m *TaskRunner) GetTask(id TaskItemId) (*TaskInfo, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.getTaskInfoLocked(id)
+}
+
+// ListTasks returns the tasks in one of states ordered by id, all tasks if no
+// state is given.
+func (m *TaskRunner) ListTasks(states ...TaskState) []*TaskInfo {
+	m.mutex.Lock()
+	ids := make(map[TaskItemId]bool, len(m.taskMap)+len(m.customTaskMap)+len(m.history.infos))
+	for id := range m.taskMap {
+		ids[id] = true
+	}
+	for id := range m.customTaskMap {
+		ids[id] = true
+	}
+	for id := range m.history.infos {
+		ids[id] = true
+	}
+	infos := make([]*TaskInfo, 0, len(ids))
+	for id := range ids {
+		if info, found := m.getTaskInfoLocked(id); found && matchesTaskState(info.State, states) {
+			infos = append(infos, info)
+		}
+	}
+	m.mutex.Unlock()
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Id < infos[j].Id
+	})
+	return infos
+}
+
+func matchesTaskState(state TaskState, states []TaskState) bool {
+	if len(states) == 0 {
+		return true
+	}
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -1,23 +1,142 @@
 package taskrunner
 
 import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
 	"k8s.io/klog/v2"
 )
 
 type taskItem struct {
-	id         TaskItemId
-	closure    TaskClosure
-	isRunning  bool
+	id          TaskItemId
+	closure     TaskResultClosure
+	isRunning   bool
+	ctx         context.Context
+	cancel      context.CancelFunc
+	timeoutInMs int64
+	priority    TaskPriority
+	retryPolicy *RetryPolicy
+	serialKey   string
+	dedupKey    string
+	category    string
+	singleton   bool
+	kind        TaskKind
+	submitTime  time.Time
+	runCount    *int32
+	// rateReserved is set while a task parked by its category limit waits
+	// to be requeued, it already holds a permit.
+	rateReserved bool
+	// busy is the taskIdle, taskBusy or taskFinished state of the task in the
+	// activity of the runner.
+	busy int32
+	// abandoned is set by a Shutdown which stopped waiting for the task.
+	abandoned int32
+	// queueWait is how long the current attempt waited for a worker.
+	queueWait  time.Duration
+	onFinish   func(err error)
+	future     *TaskFuture
 	taskRunner *TaskRunner
 }
 
 func (m *taskItem) run() {
+	var result interface{}
+	var err error
+	var startTime time.Time
+	var span *taskSpan
+	attempt := int(atomic.AddInt32(&m.future.attempts, 1))
+	if m.runCount != nil {
+		atomic.AddInt32(m.runCount, 1)
+	}
 	defer func() {
 		if r := recover(); r != nil {
-			klog.Errorf("PanicHappenedInTaskItem r:%+v", r)
-			m.taskRunner.removeTask(m.id)
+			panicErr := &TaskPanicError{
+				Id:      m.id,
+				Runner:  m.taskRunner.name,
+				Closure: closureName(m.closure),
+				Value:   r,
+				Stack:   debug.Stack(),
+			}
+			err = panicErr
+			atomic.AddUint64(&m.taskRunner.metrics.panicked, 1)
+			m.taskRunner.notifyTaskEvent(TaskPanicked, m, err)
+			m.taskRunner.handlePanic(panicErr)
+		}
+		if err != nil && atomic.LoadInt32(&m.abandoned) != 0 {
+			err = fmt.Errorf("%w: %w", ErrTaskAbandoned, err)
+		}
+		if !startTime.IsZero() {
+			m.taskRunner.metrics.runTime.observe(m.taskRunner.clock.Since(startTime))
 		}
+		if m.shouldRetry(attempt, err) {
+			backoff := m.retryPolicy.backoff(attempt)
+			span.finish(err, true, backoff)
+			m.taskRunner.retryTask(m, attempt, err, backoff)
+			return
+		}
+		span.finish(err, false, 0)
+		m.notifyFinished(err)
+		m.cancel()
+		m.taskRunner.removeTask(m)
+		m.finish(result, err)
+		m.taskRunner.metrics.taskCompleted(err)
+		m.taskRunner.slots.release()
+		m.taskRunner.runningWg.Done()
+		m.taskRunner.activity.addRunning(-1)
 	}()
-	m.closure.Run()
-	m.taskRunner.removeTask(m.id)
+	if err = m.ctx.Err(); err != nil {
+		klog.V(1).Infof("SkipCancelledTaskItem TaskRunner:%s Id:%d Error:%v", m.taskRunner.name, m.id, err)
+		return
+	}
+	if m.singleton && !m.taskRunner.IsLeader() {
+		err = ErrNotLeader
+		klog.V(1).Infof("SkipSingletonTaskItem TaskRunner:%s Id:%d", m.taskRunner.name, m.id)
+		return
+	}
+	ctx := context.WithValue(m.ctx, taskAttemptKey{}, attempt)
+	ctx, span = m.startSpan(ctx, attempt)
+	if m.timeoutInMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(m.timeoutInMs)*time.Millisecond)
+		defer cancel()
+	}
+	m.taskRunner.notifyTaskEvent(TaskStarted, m, nil)
+	startTime = m.taskRunner.clock.Now()
+	result, err = m.closure.RunResult(ctx)
+}
+
+// notifyFinished reports the final outcome of a run, TaskPanicked has already
+// been reported when the panic was recovered.
+func (m *taskItem) notifyFinished(err error) {
+	switch err.(type) {
+	case nil:
+		m.taskRunner.notifyTaskEvent(TaskSucceeded, m, nil)
+	case *TaskPanicError:
+	default:
+		if m.ctx.Err() != nil {
+			m.taskRunner.notifyTaskEvent(TaskCancelled, m, err)
+		} else {
+			m.taskRunner.notifyTaskEvent(TaskFailed, m, err)
+		}
+	}
+}
+
+func (m *taskItem) shouldRetry(attempt int, err error) bool {
+	return m.retryPolicy != nil && m.ctx.Err() == nil && m.retryPolicy.shouldRetry(attempt, err)
+}
+
+func (m *taskItem) finish(result interface{}, err error) {
+	if m.onFinish != nil {
+		m.onFinish(err)
+	}
+	m.future.complete(result, err)
+	if len(m.dedupKey) > 0 {
+		m.taskRunner.releaseDedupKey(m)
+	}
+	if len(m.serialKey) > 0 {
+		m.taskRunner.releaseSerialKey(m)
+	}
+	m.taskRunner.markTaskFinished(m)
 }
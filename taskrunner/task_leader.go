@@ -0,0 +1,140 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/sohuno/gotools/timeutils"
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrNotLeader = errors.New("task runner does not hold the leader lease")
+)
+
+// LeaderLock is a lease shared by the replicas of a runner, see
+// FileLeaderLock for a local implementation. Other backends (etcd, a
+// database row, a kubernetes Lease) only have to implement these two methods.
+type LeaderLock interface {
+	// TryAcquire takes or renews the lease without blocking, it returns
+	// true while this replica holds it.
+	TryAcquire(ctx context.Context) (bool, error)
+	Release(ctx context.Context) error
+}
+
+// WithLeaderElection makes the runner compete for lock every renewIntervalInMs
+// once started, the first attempt is made by Startup. The tasks added
+// WithSingleton only run while this replica holds the lease, the others run
+// everywhere.
+func WithLeaderElection(lock LeaderLock, renewIntervalInMs int64) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.leaderLock = lock
+		options.leaderRenewInMs = renewIntervalInMs
+	}
+}
+
+// WithLeaderChangeHandler is called whenever this replica gains or loses the
+// lease.
+func WithLeaderChangeHandler(handler func(isLeader bool)) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.onLeaderChange = handler
+	}
+}
+
+// WithSingleton marks a task to run on the leader replica only. On the other
+// replicas a one-shot task fails with ErrNotLeader and the fires of a
+// repeating, delayed or cron task are skipped.
+func WithSingleton() TaskOption {
+	return func(options *taskOptions) {
+		options.singleton = true
+	}
+}
+
+// IsLeader returns true while the runner holds the leader lease, always true
+// without leader election.
+func (m *TaskRunner) IsLeader() bool {
+	if m.leaderLock == nil {
+		return true
+	}
+	return atomic.LoadInt32(&m.isLeader) == 1
+}
+
+func (m *TaskRunner) leaderRenewInterval() time.Duration {
+	interval := time.Duration(m.leaderRenewInMs) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return interval
+}
+
+// startLeaderElection takes the lease before Startup returns, so that the
+// replica which wins accepts its singleton tasks from the start, and renews
+// it in the background.
+func (m *TaskRunner) startLeaderElection() {
+	interval := m.leaderRenewInterval()
+	m.renewLeaderLease(interval)
+	ticker := m.clock.NewTicker(interval)
+	go m.runLeaderElection(ticker, interval)
+}
+
+func (m *TaskRunner) runLeaderElection(ticker timeutils.Ticker, interval time.Duration) {
+	defer close(m.leaderDoneCh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			m.renewLeaderLease(interval)
+		case <-m.leaderQuitCh:
+			m.setLeader(false)
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			if err := m.leaderLock.Release(ctx); err != nil {
+				klog.Errorf("ReleaseLeaderLockFailed TaskRunner:%s Error:%v", m.name, err)
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+func (m *TaskRunner) renewLeaderLease(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	isLeader, err := m.leaderLock.TryAcquire(ctx)
+	if err != nil {
+		// the lease can not be proven, so it is treated as lost
+		klog.Errorf("AcquireLeaderLockFailed TaskRunner:%s Error:%v", m.name, err)
+		isLeader = false
+	}
+	m.setLeader(isLeader)
+}
+
+func (m *TaskRunner) setLeader(isLeader bool) {
+	value := int32(0)
+	if isLeader {
+		value = 1
+	}
+	if atomic.SwapInt32(&m.isLeader, value) == value {
+		return
+	}
+	klog.Infof("LeaderChanged TaskRunner:%s IsLeader:%v", m.name, isLeader)
+	if !isLeader {
+		m.cancelSingletonTasks()
+	}
+	if m.onLeaderChange != nil {
+		m.onLeaderChange(isLeader)
+	}
+}
+
+// cancelSingletonTasks cancels the singleton tasks queued or running once the
+// lease is lost, the new leader runs them instead.
+func (m *TaskRunner) cancelSingletonTasks() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, task := range m.taskMap {
+		if task.singleton {
+			task.cancel()
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// FileLeaderLock is a LeaderLock on flock(2), it elects a leader among the
+// processes of one host, which is enough for local testing. The lease is held
+// until Release or until the process exits.
+type FileLeaderLock struct {
+	path  string
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileLeaderLock(path string) *FileLeaderLock {
+	return &FileLeaderLock{
+		path: path,
+	}
+}
+
+func (m *FileLeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.file != nil {
+		return true, nil
+	}
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return false, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+	// the pid of the leader helps debugging, the lock is on the descriptor
+	if err := file.Truncate(0); err == nil {
+		_, _ = fmt.Fprintf(file, "%d\n", os.Getpid())
+	}
+	m.file = file
+	return true, nil
+}
+
+func (m *FileLeaderLock) Release(ctx context.Context) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.file == nil {
+		return nil
+	}
+	err := syscall.Flock(int(m.file.Fd()), syscall.LOCK_UN)
+	if closeErr := m.file.Close(); err == nil {
+		err = closeErr
+	}
+	m.file = nil
+	return err
+}
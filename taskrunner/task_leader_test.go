@@ -0,0 +1,155 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFileLeaderLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader")
+	first := NewFileLeaderLock(path)
+	second := NewFileLeaderLock(path)
+	ctx := context.Background()
+	if acquired, err := first.TryAcquire(ctx); !acquired || err != nil {
+		t.Fatalf("first acquired %v error %v", acquired, err)
+	}
+	if acquired, err := second.TryAcquire(ctx); acquired || err != nil {
+		t.Fatalf("second acquired %v error %v while the first holds the lock", acquired, err)
+	}
+	// renewing the held lock succeeds
+	if acquired, err := first.TryAcquire(ctx); !acquired || err != nil {
+		t.Fatalf("first renewed %v error %v", acquired, err)
+	}
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if acquired, err := second.TryAcquire(ctx); !acquired || err != nil {
+		t.Fatalf("second acquired %v error %v after the release", acquired, err)
+	}
+	if acquired, _ := first.TryAcquire(ctx); acquired {
+		t.Fatal("first acquired the lock held by the second")
+	}
+	if err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Release(ctx); err != nil {
+		t.Fatalf("second release: %v", err)
+	}
+}
+
+func TestLeaderElectionStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leader")
+	leader := NewTaskRunner("leader", 1, WithLeaderElection(NewFileLeaderLock(path), 1000))
+	leader.Startup()
+	defer leader.Shutdown(context.Background(), false)
+	follower := NewTaskRunner("follower", 1, WithLeaderElection(NewFileLeaderLock(path), 1000))
+	follower.Startup()
+	defer follower.Shutdown(context.Background(), false)
+
+	// the lease is taken by Startup, not after it
+	if !leader.IsLeader() || follower.IsLeader() {
+		t.Fatalf("leader %v, follower %v", leader.IsLeader(), follower.IsLeader())
+	}
+	closure := TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	if _, err := leader.SubmitTask(context.Background(), closure, WithSingleton()).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := follower.SubmitTask(context.Background(), closure, WithSingleton()).Wait(); !errors.Is(err, ErrNotLeader) {
+		t.Fatal(err)
+	}
+	if _, err := follower.SubmitTask(context.Background(), closure).Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type testLeaderLock struct {
+	leader int32
+}
+
+func (m *testLeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	return atomic.LoadInt32(&m.leader) == 1, nil
+}
+
+func (m *testLeaderLock) Release(ctx context.Context) error {
+	return nil
+}
+
+func waitLeader(t *testing.T, runner *TaskRunner, isLeader bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for runner.IsLeader() != isLeader {
+		if time.Now().After(deadline) {
+			t.Fatalf("IsLeader is not %v", isLeader)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSingletonLeaseLostCancel(t *testing.T) {
+	lock := &testLeaderLock{leader: 1}
+	runner := NewTaskRunner("leader", 2, WithLeaderElection(lock, 10))
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	running := runner.SubmitTask(context.Background(), TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), WithSingleton())
+	for running.Attempts() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	atomic.StoreInt32(&lock.leader, 0)
+	select {
+	case <-running.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("running singleton not cancelled when the lease was lost")
+	}
+	if _, err := running.Wait(); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	closure := TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})
+	if _, err := runner.SubmitTask(context.Background(), closure, WithSingleton()).Wait(); !errors.Is(err, ErrNotLeader) {
+		t.Fatal(err)
+	}
+}
+
+func TestSingletonLeaseLostSkip(t *testing.T) {
+	lock := &testLeaderLock{leader: 1}
+	runner, clock := newFakeClockRunner(2, WithLeaderElection(lock, 1000))
+	defer runner.Shutdown(context.Background(), false)
+	if !runner.IsLeader() {
+		t.Fatal("lease not taken by Startup")
+	}
+	var runs int32
+	// fires at 0, 1.6s, 3.2s, away from the renewals every second
+	runner.AddRepeatingTask(TaskFunc(func() {
+		atomic.AddInt32(&runs, 1)
+	}), 1600, WithSingleton())
+	clock.Advance(0)
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("%d runs of the leader, want the immediate fire", n)
+	}
+
+	atomic.StoreInt32(&lock.leader, 0)
+	clock.Advance(time.Second)
+	waitLeader(t, runner, false)
+	// the fire at 1.6s is skipped
+	clock.Advance(time.Second)
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("%d runs after the lease was lost", n)
+	}
+
+	atomic.StoreInt32(&lock.leader, 1)
+	clock.Advance(time.Second)
+	waitLeader(t, runner, true)
+	clock.Advance(time.Second)
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Fatalf("%d runs after the lease was taken again, want 2", n)
+	}
+}
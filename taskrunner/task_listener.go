@@ -0,0 +1,221 @@
+package taskrunner
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TaskLifecycleEventType int
+
+const (
+	TaskQueued TaskLifecycleEventType = iota
+	TaskStarted
+	TaskSucceeded
+	TaskFailed
+	TaskPanicked
+	TaskCancelled
+	TaskRetried
+)
+
+func (t TaskLifecycleEventType) String() string {
+	switch t {
+	case TaskQueued:
+		return "Queued"
+	case TaskStarted:
+		return "Started"
+	case TaskSucceeded:
+		return "Succeeded"
+	case TaskFailed:
+		return "Failed"
+	case TaskPanicked:
+		return "Panicked"
+	case TaskCancelled:
+		return "Cancelled"
+	case TaskRetried:
+		return "Retried"
+	default:
+		return "Unknown"
+	}
+}
+
+// TaskLifecycleEvent describes one step in the life of a task. A task ends
+// with exactly one of TaskSucceeded, TaskFailed, TaskPanicked or
+// TaskCancelled, a TaskPanicked followed by TaskRetried is not the end.
+type TaskLifecycleEvent struct {
+	Type   TaskLifecycleEventType
+	Runner string
+	Id     TaskItemId
+	// Attempt is 0 until the task started its first run.
+	Attempt int
+	Time    time.Time
+	// Err is the error of TaskFailed, TaskPanicked (a *TaskPanicError),
+	// TaskCancelled and of the failed attempt of TaskRetried.
+	Err error
+	// Backoff is the delay before the next attempt of TaskRetried.
+	Backoff time.Duration
+}
+
+// TaskListener is notified synchronously from the goroutines of the runner, it
+// must not block. Embed BaseTaskListener to implement only some methods.
+type TaskListener interface {
+	OnQueued(event *TaskLifecycleEvent)
+	OnStart(event *TaskLifecycleEvent)
+	OnSuccess(event *TaskLifecycleEvent)
+	OnFailure(event *TaskLifecycleEvent)
+	OnPanic(event *TaskLifecycleEvent)
+	OnCancel(event *TaskLifecycleEvent)
+	OnRetry(event *TaskLifecycleEvent)
+}
+
+type BaseTaskListener struct{}
+
+func (BaseTaskListener) OnQueued(event *TaskLifecycleEvent)  {}
+func (BaseTaskListener) OnStart(event *TaskLifecycleEvent)   {}
+func (BaseTaskListener) OnSuccess(event *TaskLifecycleEvent) {}
+func (BaseTaskListener) OnFailure(event *TaskLifecycleEvent) {}
+func (BaseTaskListener) OnPanic(event *TaskLifecycleEvent)   {}
+func (BaseTaskListener) OnCancel(event *TaskLifecycleEvent)  {}
+func (BaseTaskListener) OnRetry(event *TaskLifecycleEvent)   {}
+
+// WithTaskListener registers listener when the runner is created.
+func WithTaskListener(listener TaskListener) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.listeners = append(options.listeners, listener)
+	}
+}
+
+// taskListeners is a copy-on-write list so notifying does not take a lock.
+type taskListeners struct {
+	mutex     sync.Mutex
+	listeners atomic.Value // []TaskListener
+}
+
+func newTaskListeners(listeners []TaskListener) *taskListeners {
+	m := &taskListeners{}
+	m.listeners.Store(append([]TaskListener(nil), listeners...))
+	return m
+}
+
+func (m *taskListeners) add(listener TaskListener) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current := m.listeners.Load().([]TaskListener)
+	m.listeners.Store(append(append([]TaskListener(nil), current...), listener))
+}
+
+func (m *taskListeners) remove(listener TaskListener) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	current := m.listeners.Load().([]TaskListener)
+	listeners := make([]TaskListener, 0, len(current))
+	for _, l := range current {
+		if l != listener {
+			listeners = append(listeners, l)
+		}
+	}
+	m.listeners.Store(listeners)
+}
+
+func (m *taskListeners) notify(event *TaskLifecycleEvent) {
+	for _, listener := range m.listeners.Load().([]TaskListener) {
+		switch event.Type {
+		case TaskQueued:
+			listener.OnQueued(event)
+		case TaskStarted:
+			listener.OnStart(event)
+		case TaskSucceeded:
+			listener.OnSuccess(event)
+		case TaskFailed:
+			listener.OnFailure(event)
+		case TaskPanicked:
+			listener.OnPanic(event)
+		case TaskCancelled:
+			listener.OnCancel(event)
+		case TaskRetried:
+			listener.OnRetry(event)
+		}
+	}
+}
+
+func (m *TaskRunner) AddTaskListener(listener TaskListener) {
+	m.listeners.add(listener)
+}
+
+func (m *TaskRunner) RemoveTaskListener(listener TaskListener) {
+	m.listeners.remove(listener)
+}
+
+// TaskSubscription delivers the lifecycle events of a runner on a channel.
+// Events are dropped rather than blocking the runner when the subscriber
+// falls behind, Dropped counts them.
+type TaskSubscription struct {
+	runner  *TaskRunner
+	ch      chan TaskLifecycleEvent
+	dropped uint64
+	once    sync.Once
+	mutex   sync.RWMutex
+	closed  bool
+}
+
+// Subscribe returns a subscription buffering up to bufferSize events, it has
+// to be closed with Unsubscribe.
+func (m *TaskRunner) Subscribe(bufferSize int) *TaskSubscription {
+	subscription := &TaskSubscription{
+		runner: m,
+		ch:     make(chan TaskLifecycleEvent, bufferSize),
+	}
+	m.listeners.add(subscription)
+	return subscription
+}
+
+// Events is closed by Unsubscribe.
+func (m *TaskSubscription) Events() <-chan TaskLifecycleEvent {
+	return m.ch
+}
+
+func (m *TaskSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
+func (m *TaskSubscription) Unsubscribe() {
+	m.once.Do(func() {
+		m.runner.listeners.remove(m)
+		m.mutex.Lock()
+		m.closed = true
+		close(m.ch)
+		m.mutex.Unlock()
+	})
+}
+
+func (m *TaskSubscription) deliver(event *TaskLifecycleEvent) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.ch <- *event:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+func (m *TaskSubscription) OnQueued(event *TaskLifecycleEvent)  { m.deliver(event) }
+func (m *TaskSubscription) OnStart(event *TaskLifecycleEvent)   { m.deliver(event) }
+func (m *TaskSubscription) OnSuccess(event *TaskLifecycleEvent) { m.deliver(event) }
+func (m *TaskSubscription) OnFailure(event *TaskLifecycleEvent) { m.deliver(event) }
+func (m *TaskSubscription) OnPanic(event *TaskLifecycleEvent)   { m.deliver(event) }
+func (m *TaskSubscription) OnCancel(event *TaskLifecycleEvent)  { m.deliver(event) }
+func (m *TaskSubscription) OnRetry(event *TaskLifecycleEvent)   { m.deliver(event) }
+
+func (m *TaskRunner) notifyTaskEvent(eventType TaskLifecycleEventType, task *taskItem, err error) {
+	m.listeners.notify(&TaskLifecycleEvent{
+		Type:    eventType,
+		Runner:  m.name,
+		Id:      task.id,
+		Attempt: int(atomic.LoadInt32(&task.future.attempts)),
+		Time:    m.clock.Now(),
+		Err:     err,
+	})
+}
@@ -0,0 +1,101 @@
+package taskrunner
+
+import (
+	"context"
+	"time"
+)
+
+type TaskOption func(options *taskOptions)
+
+type taskOptions struct {
+	ctx             context.Context
+	timeoutInMs     int64
+	deadline        time.Time
+	priority        TaskPriority
+	retryPolicy     *RetryPolicy
+	serialKey       string
+	dedupKey        string
+	dedupPolicy     DedupPolicy
+	dedupWindowInMs int64
+	category        string
+	singleton       bool
+	kind            TaskKind
+	submitTime      time.Time
+	// runCount counts the runs of a repeating, delayed or cron task.
+	runCount *int32
+
+	repeatMode       RepeatMode
+	overlapPolicy    OverlapPolicy
+	initialDelayInMs int64
+	repeatJitterInMs int64
+	// onFinish is called once with the final error of a task created with
+	// these options, whether it ran or was dropped.
+	onFinish func(err error)
+}
+
+// WithTimeout bounds the runtime of every execution of the task, the timer
+// starts when the closure is picked up by a worker.
+func WithTimeout(timeoutInMs int64) TaskOption {
+	return func(options *taskOptions) {
+		options.timeoutInMs = timeoutInMs
+	}
+}
+
+// WithDeadline sets an absolute deadline for the task. A task whose deadline
+// has passed before it gets a worker is dropped without being run.
+func WithDeadline(deadline time.Time) TaskOption {
+	return func(options *taskOptions) {
+		options.deadline = deadline
+	}
+}
+
+// WithPriority sets the dispatch priority of the task, TaskPriorityNormal is
+// used by default.
+func WithPriority(priority TaskPriority) TaskOption {
+	return func(options *taskOptions) {
+		options.priority = priority
+	}
+}
+
+func newTaskOptions(ctx context.Context, opts []TaskOption) *taskOptions {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	options := &taskOptions{
+		ctx:      ctx,
+		priority: TaskPriorityNormal,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// newContext derives the cancelable context owned by one task item.
+func (m *taskOptions) newContext() (context.Context, context.CancelFunc) {
+	if !m.deadline.IsZero() {
+		return context.WithDeadline(m.ctx, m.deadline)
+	}
+	return context.WithCancel(m.ctx)
+}
+
+// runOptions returns the options used for each run scheduled by a repeating
+// or delayed task, the deadline is already applied to the parent ctx.
+func (m *taskOptions) runOptions(ctx context.Context) *taskOptions {
+	return &taskOptions{
+		ctx:             ctx,
+		timeoutInMs:     m.timeoutInMs,
+		priority:        m.priority,
+		retryPolicy:     m.retryPolicy,
+		serialKey:       m.serialKey,
+		dedupKey:        m.dedupKey,
+		dedupPolicy:     m.dedupPolicy,
+		dedupWindowInMs: m.dedupWindowInMs,
+		category:        m.category,
+		singleton:       m.singleton,
+		kind:            m.kind,
+		submitTime:      m.submitTime,
+		runCount:        m.runCount,
+		onFinish:        m.onFinish,
+	}
+}
@@ -0,0 +1,81 @@
+package taskrunner
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+
+	"k8s.io/klog/v2"
+)
+
+// PanicHandler is called by the worker whose closure panicked, after the panic
+// was counted in TaskRunnerStats.PanickedTasks and before the task is retried
+// or completed with err.
+type PanicHandler func(err *TaskPanicError)
+
+// WithPanicHandler replaces LogPanicHandler, the default handler. Use
+// ChainPanicHandlers to keep the log and also report to a sink.
+func WithPanicHandler(handler PanicHandler) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		if handler == nil {
+			handler = LogPanicHandler
+		}
+		options.panicHandler = handler
+	}
+}
+
+// LogPanicHandler logs the panic with its stack.
+func LogPanicHandler(err *TaskPanicError) {
+	klog.Errorf("PanicHappenedInTaskItem TaskRunner:%s Id:%d Closure:%s r:%+v\n%s",
+		err.Runner, err.Id, err.Closure, err.Value, err.Stack)
+}
+
+// CrashPanicHandler logs the panic and exits the process, for the services
+// which prefer to be restarted over running with a broken state.
+func CrashPanicHandler(err *TaskPanicError) {
+	LogPanicHandler(err)
+	klog.Flush()
+	os.Exit(2)
+}
+
+// ChainPanicHandlers calls handlers in order.
+func ChainPanicHandlers(handlers ...PanicHandler) PanicHandler {
+	return func(err *TaskPanicError) {
+		for _, handler := range handlers {
+			handler(err)
+		}
+	}
+}
+
+func (m *TaskRunner) handlePanic(err *TaskPanicError) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("PanicHappenedInPanicHandler TaskRunner:%s Id:%d r:%+v", m.name, err.Id, r)
+		}
+	}()
+	m.panicHandler(err)
+}
+
+// closureName names the closure given to the runner, the adapters of TaskRunner
+// are unwrapped and a func closure is named after its function.
+func closureName(closure interface{}) string {
+	for {
+		switch adapter := closure.(type) {
+		case *resultClosureAdapter:
+			closure = adapter.closure
+			continue
+		case *contextClosureAdapter:
+			closure = adapter.closure
+			continue
+		}
+		break
+	}
+	value := reflect.ValueOf(closure)
+	if value.Kind() == reflect.Func && !value.IsNil() {
+		if function := runtime.FuncForPC(value.Pointer()); function != nil {
+			return function.Name()
+		}
+	}
+	return fmt.Sprintf("%T", closure)
+}
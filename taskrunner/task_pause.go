@@ -0,0 +1,121 @@
+package taskrunner
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/klog/v2"
+)
+
+// pauseGate holds back the scheduler while the runner is paused.
+type pauseGate struct {
+	mutex    sync.Mutex
+	resumeCh chan struct{}
+}
+
+func (m *pauseGate) pause() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.resumeCh != nil {
+		return false
+	}
+	m.resumeCh = make(chan struct{})
+	return true
+}
+
+func (m *pauseGate) resume() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.resumeCh == nil {
+		return false
+	}
+	close(m.resumeCh)
+	m.resumeCh = nil
+	return true
+}
+
+func (m *pauseGate) isPaused() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.resumeCh != nil
+}
+
+// wait blocks while paused, it returns false if quitCh was closed.
+func (m *pauseGate) wait(quitCh <-chan struct{}) bool {
+	m.mutex.Lock()
+	resumeCh := m.resumeCh
+	m.mutex.Unlock()
+	if resumeCh == nil {
+		return true
+	}
+	select {
+	case <-resumeCh:
+		return true
+	case <-quitCh:
+		return false
+	}
+}
+
+// Pause stops dispatching tasks, new tasks are still accepted and queued and
+// the running tasks complete. Repeating and cron tasks keep firing into the
+// queue, use PauseTask to stop them too.
+func (m *TaskRunner) Pause() {
+	if m.pauseGate.pause() {
+		m.activity.changed()
+		klog.Infof("TaskRunnerPaused TaskRunner:%s", m.name)
+	}
+}
+
+func (m *TaskRunner) Resume() {
+	if m.pauseGate.resume() {
+		m.activity.changed()
+		klog.Infof("TaskRunnerResumed TaskRunner:%s", m.name)
+	}
+}
+
+func (m *TaskRunner) IsPaused() bool {
+	return m.pauseGate.isPaused()
+}
+
+// schedulePause is embedded by the repeating, delayed and cron tasks. A
+// paused task keeps its schedule but does not fire, a delayed task whose fire
+// time passed while paused fires when resumed.
+type schedulePause struct {
+	paused int32
+}
+
+func (m *schedulePause) pause() {
+	atomic.StoreInt32(&m.paused, 1)
+}
+
+func (m *schedulePause) resume() {
+	atomic.StoreInt32(&m.paused, 0)
+}
+
+func (m *schedulePause) isPaused() bool {
+	return atomic.LoadInt32(&m.paused) == 1
+}
+
+// PauseTask pauses the repeating, delayed or cron task id, a run already
+// queued or running is not affected. It returns false if id is not scheduled.
+func (m *TaskRunner) PauseTask(id TaskItemId) bool {
+	m.mutex.Lock()
+	task, found := m.customTaskMap[id]
+	m.mutex.Unlock()
+	if found {
+		task.pause()
+		klog.V(1).Infof("TaskPaused TaskRunner:%s Id:%d", m.name, id)
+	}
+	return found
+}
+
+func (m *TaskRunner) ResumeTask(id TaskItemId) bool {
+	m.mutex.Lock()
+	task, found := m.customTaskMap[id]
+	m.mutex.Unlock()
+	if found {
+		task.resume()
+		klog.V(1).Infof("TaskResumed TaskRunner:%s Id:%d", m.name, id)
+	}
+	return found
+}
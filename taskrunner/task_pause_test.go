@@ -0,0 +1,36 @@
+package taskrunner
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPauseIdleRunner(t *testing.T) {
+	runner := NewTaskRunner("pause", 2)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	// lets the scheduler block on the queue
+	time.Sleep(10 * time.Millisecond)
+
+	runner.Pause()
+	var runs int32
+	for i := 0; i < 2; i++ {
+		runner.AddTask(TaskFunc(func() {
+			atomic.AddInt32(&runs, 1)
+		}))
+	}
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != 0 {
+		t.Fatalf("%d tasks ran while paused", n)
+	}
+	runner.Resume()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runs) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Fatalf("%d tasks ran after Resume", n)
+	}
+}
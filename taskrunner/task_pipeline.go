@@ -0,0 +1,287 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Pipeline connects stages running on TaskRunners with bounded channels. The
+// first error of a stage, or of its source, cancels the ctx of the pipeline so
+// that every stage stops reading and the channels get closed, Wait then
+// reports that error.
+type Pipeline struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	mutex  sync.Mutex
+	err    error
+}
+
+func NewPipeline(ctx context.Context) *Pipeline {
+	pipeline := &Pipeline{}
+	pipeline.ctx, pipeline.cancel = context.WithCancel(ctx)
+	return pipeline
+}
+
+// Context returns the ctx of the pipeline, it is cancelled on the first error.
+func (m *Pipeline) Context() context.Context {
+	return m.ctx
+}
+
+// Cancel stops the pipeline, Wait then returns context.Canceled.
+func (m *Pipeline) Cancel() {
+	m.fail(context.Canceled)
+}
+
+// Wait blocks until all the stages are done and returns the first error.
+func (m *Pipeline) Wait() error {
+	m.wg.Wait()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.err == nil {
+		m.err = m.ctx.Err()
+	}
+	m.cancel()
+	return m.err
+}
+
+func (m *Pipeline) fail(err error) {
+	m.mutex.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	m.mutex.Unlock()
+	m.cancel()
+}
+
+type stageOptions struct {
+	concurrency int
+	bufferSize  int
+	ordered     bool
+	taskOptions []TaskOption
+}
+
+type StageOption func(options *stageOptions)
+
+// WithStageConcurrency bounds the items processed at the same time by a stage,
+// 1 by default.
+func WithStageConcurrency(concurrency int) StageOption {
+	return func(options *stageOptions) {
+		options.concurrency = concurrency
+	}
+}
+
+// WithStageBuffer sets the capacity of the output channel of a stage.
+func WithStageBuffer(size int) StageOption {
+	return func(options *stageOptions) {
+		options.bufferSize = size
+	}
+}
+
+// WithStageOrdered makes a stage emit its outputs in the order of its inputs,
+// a slow item then holds back at most concurrency items behind it.
+func WithStageOrdered() StageOption {
+	return func(options *stageOptions) {
+		options.ordered = true
+	}
+}
+
+// WithStageTaskOptions applies opts to the tasks submitted by a stage.
+func WithStageTaskOptions(opts ...TaskOption) StageOption {
+	return func(options *stageOptions) {
+		options.taskOptions = append(options.taskOptions, opts...)
+	}
+}
+
+func newStageOptions(opts []StageOption) *stageOptions {
+	options := &stageOptions{
+		concurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	if options.concurrency <= 0 {
+		options.concurrency = 1
+	}
+	return options
+}
+
+// AddSource runs fn as a task of runner, the items passed to emit are sent on
+// the returned channel. emit returns false once the pipeline is cancelled.
+// The source holds a worker of runner until fn returns, a stage sharing the
+// runner needs another one.
+func AddSource[Out any](pipeline *Pipeline, runner *TaskRunner, fn func(ctx context.Context, emit func(item Out) bool) error, opts ...StageOption) <-chan Out {
+	options := newStageOptions(opts)
+	outCh := make(chan Out, options.bufferSize)
+	emit := func(item Out) bool {
+		select {
+		case outCh <- item:
+			return true
+		case <-pipeline.ctx.Done():
+			return false
+		}
+	}
+	pipeline.wg.Add(1)
+	pipeline.submit(runner, func(ctx context.Context) error {
+		return fn(ctx, emit)
+	}, options, func(err error) {
+		close(outCh)
+		pipeline.wg.Done()
+	})
+	return outCh
+}
+
+// AddStage applies fn to every item of inCh with tasks of runner and sends
+// the results on the returned channel, which is closed once inCh is closed
+// and all the items are processed or once the pipeline is cancelled.
+func AddStage[In, Out any](pipeline *Pipeline, runner *TaskRunner, inCh <-chan In, fn func(ctx context.Context, item In) (Out, error), opts ...StageOption) <-chan Out {
+	options := newStageOptions(opts)
+	outCh := make(chan Out, options.bufferSize)
+	stage := &pipelineStage[In, Out]{
+		pipeline:  pipeline,
+		runner:    runner,
+		fn:        fn,
+		options:   options,
+		outCh:     outCh,
+		semaphore: make(chan struct{}, options.concurrency),
+	}
+	pipeline.wg.Add(1)
+	go stage.dispatch(inCh)
+	return outCh
+}
+
+// AddSink consumes inCh with fn, it is the last stage of a pipeline.
+func AddSink[In any](pipeline *Pipeline, runner *TaskRunner, inCh <-chan In, fn func(ctx context.Context, item In) error, opts ...StageOption) {
+	outCh := AddStage(pipeline, runner, inCh, func(ctx context.Context, item In) (struct{}, error) {
+		return struct{}{}, fn(ctx, item)
+	}, opts...)
+	pipeline.wg.Add(1)
+	go func() {
+		defer pipeline.wg.Done()
+		for range outCh {
+		}
+	}()
+}
+
+type stageResult[Out any] struct {
+	value Out
+	err   error
+}
+
+type pipelineStage[In, Out any] struct {
+	pipeline  *Pipeline
+	runner    *TaskRunner
+	fn        func(ctx context.Context, item In) (Out, error)
+	options   *stageOptions
+	outCh     chan Out
+	semaphore chan struct{}
+	running   sync.WaitGroup
+}
+
+// dispatch submits one task per item, the semaphore bounds the items between
+// the read of inCh and the send of their result. The results are sent by an
+// emitter goroutine so that a slow consumer does not hold the workers.
+func (m *pipelineStage[In, Out]) dispatch(inCh <-chan In) {
+	defer m.pipeline.wg.Done()
+	var resultsCh chan chan stageResult[Out]
+	var resultCh chan stageResult[Out]
+	emitterDoneCh := make(chan struct{})
+	if m.options.ordered {
+		resultsCh = make(chan chan stageResult[Out], m.options.concurrency)
+		go m.emitOrdered(resultsCh, emitterDoneCh)
+	} else {
+		// the semaphore keeps the results below the capacity, the tasks never
+		// block on it
+		resultCh = make(chan stageResult[Out], m.options.concurrency)
+		go m.emitUnordered(resultCh, emitterDoneCh)
+	}
+	ctx := m.pipeline.ctx
+	for {
+		var item In
+		var ok bool
+		select {
+		case item, ok = <-inCh:
+		case <-ctx.Done():
+		}
+		if !ok {
+			break
+		}
+		select {
+		case m.semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		m.running.Add(1)
+		if m.options.ordered {
+			itemResultCh := make(chan stageResult[Out], 1)
+			resultsCh <- itemResultCh
+			m.process(item, itemResultCh)
+		} else {
+			m.process(item, resultCh)
+		}
+	}
+	if resultsCh != nil {
+		close(resultsCh)
+	}
+	m.running.Wait()
+	if resultCh != nil {
+		close(resultCh)
+	}
+	<-emitterDoneCh
+	close(m.outCh)
+}
+
+func (m *pipelineStage[In, Out]) process(item In, resultCh chan stageResult[Out]) {
+	var value Out
+	m.pipeline.submit(m.runner, func(ctx context.Context) error {
+		var err error
+		value, err = m.fn(ctx, item)
+		return err
+	}, m.options, func(err error) {
+		resultCh <- stageResult[Out]{value: value, err: err}
+		m.running.Done()
+	})
+}
+
+// emitUnordered forwards the results as they complete.
+func (m *pipelineStage[In, Out]) emitUnordered(resultCh chan stageResult[Out], doneCh chan struct{}) {
+	defer close(doneCh)
+	for result := range resultCh {
+		m.emit(result)
+	}
+}
+
+// emitOrdered forwards the results in the order their items were read, the
+// semaphore is released once a result leaves the stage.
+func (m *pipelineStage[In, Out]) emitOrdered(resultsCh chan chan stageResult[Out], doneCh chan struct{}) {
+	defer close(doneCh)
+	for resultCh := range resultsCh {
+		m.emit(<-resultCh)
+	}
+}
+
+// emit sends the value of a successful item and releases its place in the
+// semaphore.
+func (m *pipelineStage[In, Out]) emit(result stageResult[Out]) {
+	if result.err == nil {
+		select {
+		case m.outCh <- result.value:
+		case <-m.pipeline.ctx.Done():
+		}
+	}
+	<-m.semaphore
+}
+
+// submit runs fn as a task of runner, done is called exactly once with the
+// final error after the pipeline has recorded it.
+func (m *Pipeline) submit(runner *TaskRunner, fn func(ctx context.Context) error, options *stageOptions, done func(err error)) {
+	runner.addFuncTask(m.ctx, fn, options.taskOptions, func(err error) {
+		if err != nil && !(errors.Is(err, context.Canceled) && m.ctx.Err() != nil) {
+			m.fail(err)
+		}
+		done(err)
+	})
+}
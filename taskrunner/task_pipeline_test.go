@@ -0,0 +1,173 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func waitPipeline(t *testing.T, pipeline *Pipeline) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- pipeline.Wait()
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipeline did not complete")
+		return nil
+	}
+}
+
+func emitInts(count int) func(ctx context.Context, emit func(item int) bool) error {
+	return func(ctx context.Context, emit func(item int) bool) error {
+		for i := 0; i < count; i++ {
+			if !emit(i) {
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+}
+
+type pipelineCollector struct {
+	mutex sync.Mutex
+	items []int
+}
+
+func (m *pipelineCollector) add(ctx context.Context, item int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.items = append(m.items, item)
+	return nil
+}
+
+func TestPipelineSharedRunner(t *testing.T) {
+	// the source and the stage tasks share two workers with a sink slower
+	// than the stage, the stage tasks must not hold the workers on a full
+	// output channel
+	runner := NewTaskRunner("pipe", 2)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	pipeline := NewPipeline(context.Background())
+	sourceCh := AddSource(pipeline, runner, emitInts(50))
+	doubledCh := AddStage(pipeline, runner, sourceCh, func(ctx context.Context, item int) (int, error) {
+		return item * 2, nil
+	}, WithStageConcurrency(2))
+	collector := &pipelineCollector{}
+	AddSink(pipeline, runner, doubledCh, func(ctx context.Context, item int) error {
+		time.Sleep(time.Millisecond)
+		return collector.add(ctx, item)
+	})
+	if err := waitPipeline(t, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(collector.items)
+	if len(collector.items) != 50 || collector.items[49] != 98 {
+		t.Fatalf("items %v", collector.items)
+	}
+}
+
+func TestPipelineOrdered(t *testing.T) {
+	runner := NewTaskRunner("pipe", 8)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	pipeline := NewPipeline(context.Background())
+	sourceCh := AddSource(pipeline, runner, emitInts(40))
+	slowCh := AddStage(pipeline, runner, sourceCh, func(ctx context.Context, item int) (int, error) {
+		// the early items complete last
+		time.Sleep(time.Duration(40-item) * 100 * time.Microsecond)
+		return item, nil
+	}, WithStageConcurrency(4), WithStageOrdered())
+	collector := &pipelineCollector{}
+	AddSink(pipeline, runner, slowCh, collector.add)
+	if err := waitPipeline(t, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	if len(collector.items) != 40 || !sort.IntsAreSorted(collector.items) {
+		t.Fatalf("items %v", collector.items)
+	}
+}
+
+func TestPipelineUnordered(t *testing.T) {
+	runner := NewTaskRunner("pipe", 8)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	pipeline := NewPipeline(context.Background())
+	sourceCh := AddSource(pipeline, runner, emitInts(40))
+	slowCh := AddStage(pipeline, runner, sourceCh, func(ctx context.Context, item int) (int, error) {
+		time.Sleep(time.Duration(item%4) * time.Millisecond)
+		return item, nil
+	}, WithStageConcurrency(4), WithStageBuffer(4))
+	collector := &pipelineCollector{}
+	AddSink(pipeline, runner, slowCh, collector.add)
+	if err := waitPipeline(t, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	sort.Ints(collector.items)
+	for i, item := range collector.items {
+		if item != i {
+			t.Fatalf("items %v", collector.items)
+		}
+	}
+	if len(collector.items) != 40 {
+		t.Fatalf("%d items", len(collector.items))
+	}
+}
+
+func TestPipelineStageError(t *testing.T) {
+	runner := NewTaskRunner("pipe", 4)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	errFailed := errors.New("failed")
+	pipeline := NewPipeline(context.Background())
+	// an endless source only stops once the error cancels the pipeline
+	sourceCh := AddSource(pipeline, runner, emitInts(1<<30))
+	failedCh := AddStage(pipeline, runner, sourceCh, func(ctx context.Context, item int) (int, error) {
+		if item == 5 {
+			return 0, errFailed
+		}
+		return item, nil
+	}, WithStageConcurrency(2))
+	collector := &pipelineCollector{}
+	AddSink(pipeline, runner, failedCh, collector.add)
+	if err := waitPipeline(t, pipeline); !errors.Is(err, errFailed) {
+		t.Fatal(err)
+	}
+	if pipeline.Context().Err() == nil {
+		t.Fatal("pipeline ctx not cancelled")
+	}
+	for _, item := range collector.items {
+		if item == 5 {
+			t.Fatalf("failed item emitted %v", collector.items)
+		}
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	runner := NewTaskRunner("pipe", 4)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	pipeline := NewPipeline(context.Background())
+	sourceCh := AddSource(pipeline, runner, emitInts(1<<30))
+	stageCh := AddStage(pipeline, runner, sourceCh, func(ctx context.Context, item int) (int, error) {
+		return item, nil
+	})
+	receivedCh := make(chan struct{})
+	var once sync.Once
+	AddSink(pipeline, runner, stageCh, func(ctx context.Context, item int) error {
+		once.Do(func() {
+			close(receivedCh)
+		})
+		return nil
+	})
+	<-receivedCh
+	pipeline.Cancel()
+	if err := waitPipeline(t, pipeline); !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,43 @@
+package taskrunner
+
+import (
+	"fmt"
+)
+
+// TaskPriority orders the dispatch of queued tasks, a smaller value is more
+// urgent. Tasks of the same priority are dispatched in FIFO order.
+type TaskPriority int
+
+const (
+	TaskPriorityCritical TaskPriority = iota
+	TaskPriorityHigh
+	TaskPriorityNormal
+	TaskPriorityLow
+
+	taskPriorityLevels = int(TaskPriorityLow) + 1
+)
+
+func (p TaskPriority) String() string {
+	switch p {
+	case TaskPriorityCritical:
+		return "critical"
+	case TaskPriorityHigh:
+		return "high"
+	case TaskPriorityNormal:
+		return "normal"
+	case TaskPriorityLow:
+		return "low"
+	default:
+		return fmt.Sprintf("TaskPriority(%d)", int(p))
+	}
+}
+
+func (p TaskPriority) level() int {
+	if p < TaskPriorityCritical {
+		return int(TaskPriorityCritical)
+	}
+	if int(p) >= taskPriorityLevels {
+		return taskPriorityLevels - 1
+	}
+	return int(p)
+}
@@ -0,0 +1,170 @@
+package taskrunner
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// RateLimiter paces the dispatch of tasks to the worker pool.
+type RateLimiter interface {
+	// Reserve takes a permit for one task and returns how long the task has
+	// to wait before it may run, 0 if it may run now.
+	Reserve(now time.Time) time.Duration
+}
+
+// TokenBucketLimiter allows ratePerSecond tasks on average with bursts of up
+// to burst tasks.
+type TokenBucketLimiter struct {
+	mutex      sync.Mutex
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func NewTokenBucketLimiter(ratePerSecond float64, burst int) *TokenBucketLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucketLimiter{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+func (m *TokenBucketLimiter) Reserve(now time.Time) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.rate <= 0 {
+		return 0
+	}
+	if !m.lastRefill.IsZero() && now.After(m.lastRefill) {
+		m.tokens += now.Sub(m.lastRefill).Seconds() * m.rate
+		if m.tokens > m.burst {
+			m.tokens = m.burst
+		}
+	}
+	if m.lastRefill.IsZero() || now.After(m.lastRefill) {
+		m.lastRefill = now
+	}
+	// tokens goes negative for the tasks waiting for a permit, each of them
+	// waits until its token has been refilled
+	m.tokens--
+	if m.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-m.tokens / m.rate * float64(time.Second))
+}
+
+// LeakyBucketLimiter lets one task through every interval without bursts, the
+// tasks above that rate queue up in the runner.
+type LeakyBucketLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func NewLeakyBucketLimiter(intervalInMs int64) *LeakyBucketLimiter {
+	return &LeakyBucketLimiter{
+		interval: time.Duration(intervalInMs) * time.Millisecond,
+	}
+}
+
+func (m *LeakyBucketLimiter) Reserve(now time.Time) time.Duration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.next.Before(now) {
+		m.next = now
+	}
+	wait := m.next.Sub(now)
+	m.next = m.next.Add(m.interval)
+	return wait
+}
+
+// WithRateLimit paces the dispatch of all tasks of the runner.
+func WithRateLimit(limiter RateLimiter) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.rateLimiter = limiter
+	}
+}
+
+// WithCategoryRateLimit paces the dispatch of the tasks added with
+// WithCategory(category), other tasks are not held up by them.
+func WithCategoryRateLimit(category string, limiter RateLimiter) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		if options.categoryLimiters == nil {
+			options.categoryLimiters = make(map[string]RateLimiter, 0)
+		}
+		options.categoryLimiters[category] = limiter
+	}
+}
+
+// WithCategory puts the task in a category for WithCategoryRateLimit.
+func WithCategory(category string) TaskOption {
+	return func(options *taskOptions) {
+		options.category = category
+	}
+}
+
+// throttleTask is called by the scheduler before task is submitted to the
+// pool. A task which has to wait for its category limit is parked and
+// requeued later so that it does not hold up the other tasks, the runner
+// limit is waited for in place. It returns false if task was not submitted,
+// the worker slot is released in that case.
+func (m *TaskRunner) throttleTask(task *taskItem) bool {
+	if limiter, found := m.categoryLimiters[task.category]; found && !task.rateReserved {
+		if wait := limiter.Reserve(m.clock.Now()); wait > 0 {
+			m.parkRateLimitedTask(task, wait)
+			return false
+		}
+	}
+	task.rateReserved = false
+	if m.rateLimiter == nil {
+		return true
+	}
+	wait := m.rateLimiter.Reserve(m.clock.Now())
+	if wait <= 0 {
+		return true
+	}
+	m.metrics.rateLimitWait.observe(wait)
+	atomic.AddUint64(&m.metrics.rateLimited, 1)
+	// the queued tasks wait on the clock as well while the scheduler waits
+	m.activity.setBlocked(true)
+	readyCh := make(chan struct{})
+	timer := m.clock.AfterFunc(wait, func() {
+		m.activity.setBlocked(false)
+		close(readyCh)
+	})
+	select {
+	case <-readyCh:
+		return true
+	case <-m.quitCh:
+		timer.Stop()
+		m.activity.setBlocked(false)
+		m.mutex.Lock()
+		task.isRunning = false
+		m.mutex.Unlock()
+		m.slots.release()
+		return false
+	}
+}
+
+func (m *TaskRunner) parkRateLimitedTask(task *taskItem, wait time.Duration) {
+	klog.V(1).Infof("TaskRateLimited TaskRunner:%s Id:%d Category:%s Wait:%v", m.name, task.id, task.category, wait)
+	m.metrics.rateLimitWait.observe(wait)
+	atomic.AddUint64(&m.metrics.rateLimited, 1)
+	task.rateReserved = true
+	m.mutex.Lock()
+	task.isRunning = false
+	m.mutex.Unlock()
+	m.slots.release()
+	m.markTaskIdle(task)
+	m.clock.AfterFunc(wait, func() {
+		m.markTaskBusy(task)
+		m.requeueTask(task)
+	})
+}
@@ -0,0 +1,95 @@
+package taskrunner
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	limiter := NewTokenBucketLimiter(10, 3)
+	now := time.Unix(1000, 0)
+	waits := []time.Duration{0, 0, 0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, want := range waits {
+		if wait := limiter.Reserve(now); wait != want {
+			t.Fatalf("reserve %d: wait %v, want %v", i, wait, want)
+		}
+	}
+	// the refill is capped at the burst
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 3; i++ {
+		if wait := limiter.Reserve(now); wait != 0 {
+			t.Fatalf("reserve %d after the refill: wait %v", i, wait)
+		}
+	}
+	if wait := limiter.Reserve(now); wait != 100*time.Millisecond {
+		t.Fatalf("wait %v above the burst", wait)
+	}
+}
+
+func TestLeakyBucketLimiter(t *testing.T) {
+	limiter := NewLeakyBucketLimiter(100)
+	now := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		if wait := limiter.Reserve(now); wait != time.Duration(i)*100*time.Millisecond {
+			t.Fatalf("reserve %d: wait %v", i, wait)
+		}
+	}
+	// no burst after an idle period
+	now = now.Add(10 * time.Second)
+	if wait := limiter.Reserve(now); wait != 0 {
+		t.Fatalf("wait %v after idle", wait)
+	}
+	if wait := limiter.Reserve(now); wait != 100*time.Millisecond {
+		t.Fatalf("wait %v", wait)
+	}
+}
+
+func addCountedTasks(runner *TaskRunner, count int, runs *int32, opts ...TaskOption) {
+	for i := 0; i < count; i++ {
+		runner.AddTask(TaskFunc(func() {
+			atomic.AddInt32(runs, 1)
+		}), opts...)
+	}
+}
+
+func TestRateLimitBurstAndRate(t *testing.T) {
+	runner, clock := newFakeClockRunner(4, WithRateLimit(NewTokenBucketLimiter(10, 3)))
+	defer runner.Shutdown(context.Background(), false)
+	var runs int32
+	addCountedTasks(runner, 6, &runs)
+	clock.Advance(0)
+	if n := atomic.LoadInt32(&runs); n != 3 {
+		t.Fatalf("%d runs in the burst, want 3", n)
+	}
+	for want := int32(4); want <= 6; want++ {
+		clock.Advance(100 * time.Millisecond)
+		if n := atomic.LoadInt32(&runs); n != want {
+			t.Fatalf("%d runs at %v, want %d", n, clock.Now(), want)
+		}
+	}
+}
+
+func TestCategoryRateLimit(t *testing.T) {
+	runner, clock := newFakeClockRunner(2, WithCategoryRateLimit("slow", NewLeakyBucketLimiter(1000)))
+	defer runner.Shutdown(context.Background(), false)
+	var slowRuns, otherRuns int32
+	addCountedTasks(runner, 3, &slowRuns, WithCategory("slow"))
+	addCountedTasks(runner, 3, &otherRuns, WithCategory("other"))
+	addCountedTasks(runner, 3, &otherRuns)
+	clock.Advance(0)
+	// the parked tasks of the slow category do not hold up the others
+	if n := atomic.LoadInt32(&otherRuns); n != 6 {
+		t.Fatalf("%d other runs, want 6", n)
+	}
+	if n := atomic.LoadInt32(&slowRuns); n != 1 {
+		t.Fatalf("%d slow runs, want 1", n)
+	}
+	for want := int32(2); want <= 3; want++ {
+		clock.Advance(time.Second)
+		if n := atomic.LoadInt32(&slowRuns); n != want {
+			t.Fatalf("%d slow runs at %v, want %d", n, clock.Now(), want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package taskrunner
+
+import (
+	"math/rand"
+	"time"
+)
+
+type RepeatMode int
+
+const (
+	// RepeatFixedRate fires every interval measured from the previous fire
+	// time, the runs which would overlap are handled by the OverlapPolicy.
+	RepeatFixedRate RepeatMode = iota
+	// RepeatFixedDelay fires interval after the previous run completed, so
+	// runs never overlap.
+	RepeatFixedDelay
+)
+
+func (m RepeatMode) String() string {
+	switch m {
+	case RepeatFixedRate:
+		return "FixedRate"
+	case RepeatFixedDelay:
+		return "FixedDelay"
+	default:
+		return "Unknown"
+	}
+}
+
+// OverlapPolicy decides what a fixed-rate task does when it fires while its
+// previous run is still queued or running.
+type OverlapPolicy int
+
+const (
+	// OverlapSkip drops the fire.
+	OverlapSkip OverlapPolicy = iota
+	// OverlapQueueOne runs once more as soon as the previous run completed,
+	// further fires in the meantime are dropped.
+	OverlapQueueOne
+	// OverlapAllowConcurrent queues the run anyway, the overlapping runs get
+	// their own ids.
+	OverlapAllowConcurrent
+)
+
+func (p OverlapPolicy) String() string {
+	switch p {
+	case OverlapSkip:
+		return "Skip"
+	case OverlapQueueOne:
+		return "QueueOne"
+	case OverlapAllowConcurrent:
+		return "AllowConcurrent"
+	default:
+		return "Unknown"
+	}
+}
+
+// WithRepeatMode sets the mode of a repeating task, RepeatFixedRate is used
+// by default.
+func WithRepeatMode(mode RepeatMode) TaskOption {
+	return func(options *taskOptions) {
+		options.repeatMode = mode
+	}
+}
+
+// WithOverlapPolicy sets the overlap policy of a fixed-rate repeating task,
+// OverlapSkip is used by default.
+func WithOverlapPolicy(policy OverlapPolicy) TaskOption {
+	return func(options *taskOptions) {
+		options.overlapPolicy = policy
+	}
+}
+
+// WithInitialDelay delays the first run of a repeating task, which otherwise
+// runs right away.
+func WithInitialDelay(initialDelayInMs int64) TaskOption {
+	return func(options *taskOptions) {
+		options.initialDelayInMs = initialDelayInMs
+	}
+}
+
+// WithRepeatJitter delays every fire of a repeating task by a random time
+// below jitterInMs, without shifting the fixed-rate schedule.
+func WithRepeatJitter(jitterInMs int64) TaskOption {
+	return func(options *taskOptions) {
+		options.repeatJitterInMs = jitterInMs
+	}
+}
+
+func (m *taskOptions) repeatJitter() time.Duration {
+	if m.repeatJitterInMs <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(m.repeatJitterInMs * int64(time.Millisecond)))
+}
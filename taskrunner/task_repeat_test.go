@@ -0,0 +1,20 @@
+package taskrunner
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRepeatingTaskInvalidInterval(t *testing.T) {
+	runner := NewTaskRunner("repeat", 1)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	for _, interval := range []int64{0, -1000} {
+		if id := runner.AddRepeatingTask(TaskFunc(func() {}), interval); id != 0 {
+			t.Fatalf("interval %d accepted as task %d", interval, id)
+		}
+	}
+	if tasks := runner.ListTasks(); len(tasks) != 0 {
+		t.Fatalf("tasks %+v", tasks)
+	}
+}
@@ -0,0 +1,82 @@
+package taskrunner
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy describes how a failed task is retried. A task fails when its
+// TaskResultClosure returns an error or when the closure panics, in which case
+// the error is a *TaskPanicError. Tasks whose ctx is done are never retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of runs including the first one.
+	MaxAttempts int
+	// InitialBackoffInMs is the delay before the second attempt.
+	InitialBackoffInMs int64
+	// MaxBackoffInMs caps the delay, 0 means no cap.
+	MaxBackoffInMs int64
+	// Multiplier grows the delay after each attempt, 2 if not set.
+	Multiplier float64
+	// Jitter randomizes the delay by +/- Jitter*delay, between 0 and 1.
+	Jitter float64
+	// RetryOn decides if err is retryable, every error is if nil.
+	RetryOn func(err error) bool
+	// OnRetry is called before a failed attempt is rescheduled.
+	OnRetry func(id TaskItemId, attempt int, err error, backoff time.Duration)
+}
+
+// WithRetryPolicy retries the task according to policy.
+func WithRetryPolicy(policy *RetryPolicy) TaskOption {
+	return func(options *taskOptions) {
+		options.retryPolicy = policy
+	}
+}
+
+func (m *RetryPolicy) shouldRetry(attempt int, err error) bool {
+	if err == nil || attempt >= m.MaxAttempts {
+		return false
+	}
+	return m.RetryOn == nil || m.RetryOn(err)
+}
+
+// maxRetryBackoffInMs is the longest delay a time.Duration holds.
+const maxRetryBackoffInMs = float64(math.MaxInt64 / int64(time.Millisecond))
+
+// backoff returns the delay after the given failed attempt, starting from 1.
+// Without MaxBackoffInMs the delay stops growing at the largest time.Duration.
+func (m *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := m.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	maxDelay := maxRetryBackoffInMs
+	if m.MaxBackoffInMs > 0 && float64(m.MaxBackoffInMs) < maxDelay {
+		maxDelay = float64(m.MaxBackoffInMs)
+	}
+	delay := float64(m.InitialBackoffInMs) * math.Pow(multiplier, float64(attempt-1))
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	if m.Jitter > 0 {
+		delay += (rand.Float64()*2 - 1) * m.Jitter * delay
+	}
+	// also drops the NaN of a 0 initial backoff times an infinite growth
+	if !(delay > 0) {
+		return 0
+	}
+	if delay >= maxRetryBackoffInMs {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(delay * float64(time.Millisecond))
+}
+
+type taskAttemptKey struct{}
+
+// TaskAttemptFromContext returns the attempt number, starting from 1, of the
+// task running with ctx. It returns 0 if ctx does not belong to a task.
+func TaskAttemptFromContext(ctx context.Context) int {
+	attempt, _ := ctx.Value(taskAttemptKey{}).(int)
+	return attempt
+}
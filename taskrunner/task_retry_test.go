@@ -0,0 +1,73 @@
+package taskrunner
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		backoff time.Duration
+	}{
+		{"first", RetryPolicy{InitialBackoffInMs: 100}, 1, 100 * time.Millisecond},
+		{"default multiplier", RetryPolicy{InitialBackoffInMs: 100}, 3, 400 * time.Millisecond},
+		{"multiplier", RetryPolicy{InitialBackoffInMs: 100, Multiplier: 3}, 3, 900 * time.Millisecond},
+		{"capped", RetryPolicy{InitialBackoffInMs: 100, MaxBackoffInMs: 250}, 3, 250 * time.Millisecond},
+		{"overflow", RetryPolicy{InitialBackoffInMs: 1000}, 40, time.Duration(math.MaxInt64)},
+		{"infinite growth", RetryPolicy{InitialBackoffInMs: 1000}, 2000, time.Duration(math.MaxInt64)},
+		{"capped infinite growth", RetryPolicy{InitialBackoffInMs: 1000, MaxBackoffInMs: 60000}, 2000, time.Minute},
+		{"no initial backoff", RetryPolicy{}, 2000, 0},
+	}
+	for _, test := range tests {
+		if backoff := test.policy.backoff(test.attempt); backoff != test.backoff {
+			t.Errorf("%s: backoff %v, want %v", test.name, backoff, test.backoff)
+		}
+	}
+}
+
+func TestRetryPolicyBackoffJitter(t *testing.T) {
+	policy := &RetryPolicy{InitialBackoffInMs: 1000, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if backoff := policy.backoff(1); backoff < 500*time.Millisecond || backoff > 1500*time.Millisecond {
+			t.Fatalf("backoff %v out of the jitter range", backoff)
+		}
+		if backoff := policy.backoff(100); backoff <= 0 {
+			t.Fatalf("backoff %v after an overflow", backoff)
+		}
+	}
+}
+
+func TestRetryPolicyShouldRetry(t *testing.T) {
+	errRetryable := errors.New("retryable")
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		RetryOn: func(err error) bool {
+			return errors.Is(err, errRetryable)
+		},
+	}
+	tests := []struct {
+		attempt int
+		err     error
+		retry   bool
+	}{
+		{1, nil, false},
+		{1, errRetryable, true},
+		{2, errRetryable, true},
+		{3, errRetryable, false},
+		{1, errors.New("fatal"), false},
+	}
+	for _, test := range tests {
+		if retry := policy.shouldRetry(test.attempt, test.err); retry != test.retry {
+			t.Errorf("attempt %d error %v: retry %v, want %v", test.attempt, test.err, retry, test.retry)
+		}
+	}
+	policy.RetryOn = nil
+	if !policy.shouldRetry(1, errors.New("any")) {
+		t.Error("every error is retryable without RetryOn")
+	}
+}
@@ -1,150 +1,779 @@
 package taskrunner
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/panjf2000/ants"
+	"github.com/sohuno/gotools/timeutils"
 	"k8s.io/klog/v2"
 )
 
 // https://pkg.go.dev/github.com/panjf2000/ants@v1.2.0
 
+var (
+	ErrTaskRunnerShutdown = errors.New("task runner is shut down")
+	ErrTaskRunnerOverload = errors.New("task runner has no free worker")
+	ErrTaskAbandoned      = errors.New("task abandoned by shutdown")
+	ErrTaskRunnerNoPool   = errors.New("task runner has no pool")
+)
+
+const (
+	taskRunnerStateCreated int32 = iota
+	taskRunnerStateRunning
+	taskRunnerStateShuttingDown
+	taskRunnerStateStopped
+)
+
 type TaskRunner struct {
 	name          string
 	taskMap       map[TaskItemId]*taskItem
 	customTaskMap map[TaskItemId]customTaskItem
+	serialKeys    map[string]*serialKeyQueue
+	dedupKeys     map[string]*dedupEntry
+	// serialPending counts the tasks parked on a serial key, serialDrainedCh
+	// is closed once it drops to 0.
+	serialPending   int
+	serialDrainedCh chan struct{}
 
 	taskClosureNextId int64
 	pool              *ants.Pool
 	eventCh           *TaskEventChannel
+	slots             *workerSlots
+	metrics           *taskRunnerMetrics
 	mutex             sync.Mutex
+
+	state           int32
+	quitCh          chan struct{}
+	quitOnce        sync.Once
+	schedulerDoneCh chan struct{}
+	store           TaskStore
+	// storeRecords are the records left in the store by a previous run, loaded
+	// before the runner accepts tasks so that its own records are not replayed.
+	storeRecords []*TaskRecord
+	storeLoadErr error
+	runningWg    sync.WaitGroup
+
+	rateLimiter      RateLimiter
+	categoryLimiters map[string]RateLimiter
+	nonblocking      bool
+	listeners        *taskListeners
+	tracer           TaskTracer
+	history          *taskHistory
+	pauseGate        pauseGate
+	timer            *taskTimer
+	clock            timeutils.Clock
+	activity         *taskActivity
+	removeSettleFunc func()
+	leaderLock       LeaderLock
+	leaderRenewInMs  int64
+	onLeaderChange   func(isLeader bool)
+	panicHandler     PanicHandler
+	isLeader         int32
+	leaderQuitCh     chan struct{}
+	leaderDoneCh     chan struct{}
+}
+
+// ShutdownReport lists the tasks which did not complete because of Shutdown.
+type ShutdownReport struct {
+	// DroppedTasks were queued but never handed to a worker.
+	DroppedTasks []TaskItemId
+	// AbandonedTasks were still running when the shutdown ctx expired, their
+	// ctx has been cancelled and a failure is reported as ErrTaskAbandoned.
+	AbandonedTasks []TaskItemId
+	// TerminatedTasks are the repeating/delayed tasks whose schedule was stopped.
+	TerminatedTasks []TaskItemId
+}
+
+// NewTaskRunner logs the error of an invalid size or option and returns a
+// runner without a pool, which rejects every task with ErrTaskRunnerNoPool.
+// Use CreateTaskRunner to handle the error.
+func NewTaskRunner(name string, size int, opts ...TaskRunnerOption) *TaskRunner {
+	options := newTaskRunnerOptions(opts)
+	pool, err := newTaskRunnerPool(size, options)
+	if err != nil {
+		klog.Errorf("NewTaskRunnerFailed TaskRunner:%s Size:%d Error:%v", name, size, err)
+	}
+	return newTaskRunner(name, size, options, pool)
 }
 
-func NewTaskRunner(name string, size int) *TaskRunner {
-	pool, _ := ants.NewPool(size)
-	return &TaskRunner{
+// CreateTaskRunner is NewTaskRunner returning the error of creating the pool.
+func CreateTaskRunner(name string, size int, opts ...TaskRunnerOption) (*TaskRunner, error) {
+	options := newTaskRunnerOptions(opts)
+	pool, err := newTaskRunnerPool(size, options)
+	if err != nil {
+		return nil, err
+	}
+	return newTaskRunner(name, size, options, pool), nil
+}
+
+func newTaskRunnerPool(size int, options *taskRunnerOptions) (*ants.Pool, error) {
+	if options.workerExpiryInMs > 0 {
+		expiryInSec := int((options.workerExpiryInMs + 999) / 1000)
+		return ants.NewTimingPool(size, expiryInSec)
+	}
+	return ants.NewPool(size)
+}
+
+func newTaskRunner(name string, size int, options *taskRunnerOptions, pool *ants.Pool) *TaskRunner {
+	activity := newTaskActivity()
+	taskRunner := &TaskRunner{
 		name:              name,
 		taskMap:           make(map[TaskItemId]*taskItem, 0),
 		customTaskMap:     make(map[TaskItemId]customTaskItem, 0),
+		serialKeys:        make(map[string]*serialKeyQueue, 0),
+		dedupKeys:         make(map[string]*dedupEntry, 0),
 		taskClosureNextId: 0,
 		pool:              pool,
-		eventCh:           NewTaskEventChannel(),
+		slots:             newWorkerSlots(size),
+		metrics:           newTaskRunnerMetrics(),
+		state:             taskRunnerStateCreated,
+		quitCh:            make(chan struct{}),
+		schedulerDoneCh:   make(chan struct{}),
+		store:             options.store,
+		rateLimiter:       options.rateLimiter,
+		categoryLimiters:  options.categoryLimiters,
+		nonblocking:       options.nonblocking,
+		listeners:         newTaskListeners(options.listeners),
+		history:           newTaskHistory(options.taskHistorySize),
+		timer:             newTaskTimer(options.clock, activity),
+		clock:             options.clock,
+		tracer:            options.tracer,
+		activity:          activity,
+		leaderLock:        options.leaderLock,
+		leaderRenewInMs:   options.leaderRenewInMs,
+		onLeaderChange:    options.onLeaderChange,
+		panicHandler:      options.panicHandler,
+		leaderQuitCh:      make(chan struct{}),
+		leaderDoneCh:      make(chan struct{}),
+	}
+	taskRunner.eventCh = NewTaskEventChannelWithConfig(TaskEventChannelConfig{
+		Capacity:          options.queueCapacity,
+		OverflowPolicy:    options.overflowPolicy,
+		AgingIntervalInMs: options.priorityAgingInMs,
+		DropHandler:       taskRunner.onTaskEventDropped,
+		Clock:             options.clock,
+	})
+	if options.store != nil {
+		taskRunner.storeRecords, taskRunner.storeLoadErr = options.store.Load()
+	}
+	return taskRunner
+}
+
+// Tune changes the number of workers of a live runner. When shrinking, the
+// running tasks above the new size complete before new tasks are dispatched.
+func (m *TaskRunner) Tune(size int) error {
+	if size <= 0 {
+		return ants.ErrInvalidPoolSize
+	}
+	if m.pool == nil {
+		return ErrTaskRunnerNoPool
 	}
+	if !m.isAcceptingTasks() {
+		return ErrTaskRunnerShutdown
+	}
+	m.pool.Tune(size)
+	m.slots.resize(size)
+	klog.Infof("TaskRunnerTuned TaskRunner:%s Size:%d", m.name, size)
+	return nil
 }
 
 func (m *TaskRunner) Startup() {
+	if !atomic.CompareAndSwapInt32(&m.state, taskRunnerStateCreated, taskRunnerStateRunning) {
+		klog.Warningf("StartupIgnored TaskRunner:%s State:%d", m.name, atomic.LoadInt32(&m.state))
+		return
+	}
+	if settler, ok := m.clock.(timeutils.Settler); ok {
+		m.removeSettleFunc = settler.AddSettleFunc(m.settle)
+	}
+	if m.leaderLock != nil {
+		m.startLeaderElection()
+	}
 	go m.scheduleOneTask()
+	if m.store != nil {
+		go m.recoverTasks()
+	}
 }
 
-func (m *TaskRunner) Shutdown() {
-	for _, task := range m.customTaskMap {
+// Shutdown stops accepting new tasks and terminates all repeating/delayed
+// tasks. With drain the tasks already queued are still dispatched, otherwise
+// they are dropped. It then waits for the running closures until ctx is done,
+// releases the pool and reports what did not complete. The returned error is
+// ctx.Err() if ctx expired before the runner was fully stopped.
+func (m *TaskRunner) Shutdown(ctx context.Context, drain bool) (*ShutdownReport, error) {
+	started := atomic.CompareAndSwapInt32(&m.state, taskRunnerStateRunning, taskRunnerStateShuttingDown)
+	if !started && !atomic.CompareAndSwapInt32(&m.state, taskRunnerStateCreated, taskRunnerStateShuttingDown) {
+		return nil, ErrTaskRunnerShutdown
+	}
+	report := &ShutdownReport{}
+
+	m.mutex.Lock()
+	customTasks := m.customTaskMap
+	m.customTaskMap = make(map[TaskItemId]customTaskItem, 0)
+	m.mutex.Unlock()
+	for id, task := range customTasks {
 		task.terminate()
+		report.TerminatedTasks = append(report.TerminatedTasks, id)
+	}
+
+	// a paused runner would never drain its queue
+	m.pauseGate.resume()
+	if started && drain {
+		// the tasks parked on a serial key are only queued once the tasks
+		// before them completed
+		if waitErr := m.waitSerialTasksQueued(ctx); waitErr != nil {
+			klog.Warningf("WaitSerialTasksTimeout TaskRunner:%s Error:%v", m.name, waitErr)
+		}
+	}
+	m.eventCh.Close()
+
+	var err error
+	if started {
+		if !drain {
+			m.quit()
+		}
+		select {
+		case <-m.schedulerDoneCh:
+		case <-ctx.Done():
+			m.quit()
+			<-m.schedulerDoneCh
+			err = ctx.Err()
+		}
+	} else {
+		m.discardQueuedEvents()
+	}
+
+	var queuedTasks []*taskItem
+	m.mutex.Lock()
+	for _, task := range m.taskMap {
+		if !task.isRunning {
+			queuedTasks = append(queuedTasks, task)
+		}
+	}
+	m.mutex.Unlock()
+	for _, task := range queuedTasks {
+		if m.dropTask(task, ErrTaskRunnerShutdown) {
+			report.DroppedTasks = append(report.DroppedTasks, task.id)
+		}
+	}
+
+	runningDoneCh := make(chan struct{})
+	go func() {
+		m.runningWg.Wait()
+		close(runningDoneCh)
+	}()
+	select {
+	case <-runningDoneCh:
+	case <-ctx.Done():
+		err = ctx.Err()
+		m.mutex.Lock()
+		for id, task := range m.taskMap {
+			atomic.StoreInt32(&task.abandoned, 1)
+			task.cancel()
+			report.AbandonedTasks = append(report.AbandonedTasks, id)
+		}
+		m.mutex.Unlock()
+	}
+
+	if started && m.leaderLock != nil {
+		// the lease is kept while draining, the queued singletons still run
+		close(m.leaderQuitCh)
+		<-m.leaderDoneCh
 	}
+	if m.pool != nil {
+		_ = m.pool.Release()
+	}
+	if m.removeSettleFunc != nil {
+		m.removeSettleFunc()
+	}
+	atomic.StoreInt32(&m.state, taskRunnerStateStopped)
+	klog.Infof("TaskRunnerShutdown Name:%s Dropped:%d Abandoned:%d Terminated:%d Error:%v",
+		m.name, len(report.DroppedTasks), len(report.AbandonedTasks), len(report.TerminatedTasks), err)
+	return report, err
+}
+
+func (m *TaskRunner) quit() {
+	m.quitOnce.Do(func() {
+		close(m.quitCh)
+	})
+}
+
+// discardQueuedEvents consumes the ids left in the event channel so that its
+// goroutine exits, the dropped tasks themselves are still in taskMap.
+func (m *TaskRunner) discardQueuedEvents() {
+	for range m.eventCh.RecvCh {
+	}
+}
+
+func (m *TaskRunner) isAcceptingTasks() bool {
+	state := atomic.LoadInt32(&m.state)
+	return state == taskRunnerStateCreated || state == taskRunnerStateRunning
+}
+
+func (m *TaskRunner) AddTask(closure TaskClosure, opts ...TaskOption) TaskItemId {
+	return m.AddTaskContext(context.Background(), toContextClosure(closure), opts...)
 }
 
-func (m *TaskRunner) AddTask(closure TaskClosure) TaskItemId {
+// AddTaskContext adds a one-shot task, the ctx passed to the closure is
+// derived from ctx and is cancelled by CancelTask or an expired deadline.
+func (m *TaskRunner) AddTaskContext(ctx context.Context, closure TaskContextClosure, opts ...TaskOption) TaskItemId {
+	return m.SubmitTask(ctx, toResultClosure(closure), opts...).Id()
+}
+
+// SubmitTask adds a one-shot task and returns a TaskFuture which carries the
+// result and error of the closure. A panic of the closure is reported as
+// *TaskPanicError, a task skipped because its ctx was done reports ctx.Err().
+func (m *TaskRunner) SubmitTask(ctx context.Context, closure TaskResultClosure, opts ...TaskOption) *TaskFuture {
+	id := m.getUniqueTaskId()
+	future, _ := m.addTaskInternal(id, closure, newTaskOptions(ctx, opts), false)
+	return future
+}
+
+// TryAddTask is the non-blocking variant of AddTask, it returns
+// ErrTaskQueueFull when the queue is at capacity instead of waiting for room.
+func (m *TaskRunner) TryAddTask(closure TaskClosure, opts ...TaskOption) (TaskItemId, error) {
 	id := m.getUniqueTaskId()
-	return m.addTaskInternal(id, closure)
+	future, err := m.addTaskInternal(id, toResultClosure(toContextClosure(closure)), newTaskOptions(context.Background(), opts), true)
+	if err != nil {
+		return 0, err
+	}
+	return future.Id(), nil
 }
 
-func (m *TaskRunner) addTaskInternal(id TaskItemId, closure TaskClosure) TaskItemId {
+// addFuncTask runs fn as a task, done is called exactly once with its final
+// error, also when the runner rejects it.
+func (m *TaskRunner) addFuncTask(ctx context.Context, fn func(ctx context.Context) error, opts []TaskOption, done func(err error)) {
+	var once sync.Once
+	finish := func(err error) {
+		once.Do(func() {
+			done(err)
+		})
+	}
+	options := newTaskOptions(ctx, opts)
+	options.onFinish = finish
+	closure := TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, fn(ctx)
+	})
+	// a task rejected by the runner is not finished, a dropped one already is
+	if _, err := m.addTaskInternal(m.getUniqueTaskId(), closure, options, false); err != nil {
+		finish(err)
+	}
+}
+
+func (m *TaskRunner) addTaskInternal(id TaskItemId, closure TaskResultClosure, options *taskOptions, nonBlocking bool) (*TaskFuture, error) {
+	if !m.isAcceptingTasks() {
+		klog.Warningf("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, id, ErrTaskRunnerShutdown)
+		return newCompletedTaskFuture(0, ErrTaskRunnerShutdown), ErrTaskRunnerShutdown
+	}
+	if m.pool == nil {
+		klog.Warningf("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, id, ErrTaskRunnerNoPool)
+		return newCompletedTaskFuture(0, ErrTaskRunnerNoPool), ErrTaskRunnerNoPool
+	}
 	m.mutex.Lock()
-	if _, found := m.taskMap[id]; found {
+	if task, found := m.taskMap[id]; found {
+		m.mutex.Unlock()
+		return task.future, nil
+	}
+	if len(options.dedupKey) > 0 {
+		if future, found := m.coalesceTaskLocked(closure, options); found {
+			m.mutex.Unlock()
+			return future, nil
+		}
+	}
+	if options.singleton && !m.IsLeader() {
 		m.mutex.Unlock()
-		return id
+		klog.V(1).Infof("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, id, ErrNotLeader)
+		return newCompletedTaskFuture(0, ErrNotLeader), ErrNotLeader
 	}
-	m.taskMap[id] = &taskItem{
-		id:         id,
-		closure:    closure,
-		isRunning:  false,
-		taskRunner: m,
+	if m.nonblocking && len(m.taskMap) >= m.slots.size() {
+		m.mutex.Unlock()
+		klog.Warningf("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, id, ErrTaskRunnerOverload)
+		return newCompletedTaskFuture(0, ErrTaskRunnerOverload), ErrTaskRunnerOverload
+	}
+	ctx, cancel := options.newContext()
+	submitTime := options.submitTime
+	if submitTime.IsZero() {
+		submitTime = m.clock.Now()
+	}
+	task := &taskItem{
+		id:          id,
+		closure:     closure,
+		isRunning:   false,
+		ctx:         ctx,
+		cancel:      cancel,
+		timeoutInMs: options.timeoutInMs,
+		priority:    options.priority,
+		retryPolicy: options.retryPolicy,
+		serialKey:   options.serialKey,
+		dedupKey:    options.dedupKey,
+		category:    options.category,
+		singleton:   options.singleton,
+		kind:        options.kind,
+		submitTime:  submitTime,
+		runCount:    options.runCount,
+		onFinish:    options.onFinish,
+		future:      newTaskFuture(id),
+		taskRunner:  m,
+	}
+	m.taskMap[id] = task
+	var dedup *dedupEntry
+	if len(task.dedupKey) > 0 {
+		dedup = m.trackDedupKeyLocked(task, options)
 	}
 	m.mutex.Unlock()
-	m.eventCh.SendCh <- id
-	return 0
+	atomic.AddUint64(&m.metrics.submitted, 1)
+	if dedup != nil && dedup.policy == DedupDebounce {
+		m.notifyTaskEvent(TaskQueued, task, nil)
+		m.debounceTask(dedup)
+		return task.future, nil
+	}
+	// notified before the event is sent so that TaskQueued precedes TaskStarted
+	m.notifyTaskEvent(TaskQueued, task, nil)
+	if err := m.queueTask(task, nonBlocking); err != nil {
+		return newCompletedTaskFuture(0, err), err
+	}
+	return task.future, nil
 }
 
-func (m *TaskRunner) AddRepeatingTask(closure TaskClosure, repeatingIntervalInMs int64) TaskItemId {
+// queueTask sends the event of a task in taskMap, a task whose serial key is
+// busy is parked until its turn.
+func (m *TaskRunner) queueTask(task *taskItem, nonBlocking bool) error {
+	if len(task.serialKey) > 0 && !m.acquireSerialKey(task) {
+		return nil
+	}
+	m.markTaskBusy(task)
+	if err := m.sendTaskEvent(task, nonBlocking); err != nil {
+		klog.Warningf("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, task.id, err)
+		m.dropTask(task, err)
+		return err
+	}
+	return nil
+}
+
+func (m *TaskRunner) sendTaskEvent(task *taskItem, nonBlocking bool) error {
+	event := TaskEvent{
+		Id:          task.id,
+		Priority:    task.priority,
+		EnqueueTime: m.clock.Now(),
+	}
+	if nonBlocking {
+		return m.eventCh.TrySend(event)
+	}
+	return m.eventCh.Send(event)
+}
+
+// dropTask removes a task which will not run and completes its future with
+// err. It returns false if the task was already gone.
+func (m *TaskRunner) dropTask(task *taskItem, err error) bool {
+	m.mutex.Lock()
+	if current, found := m.taskMap[task.id]; !found || current != task {
+		m.mutex.Unlock()
+		return false
+	}
+	delete(m.taskMap, task.id)
+	m.taskFinishedLocked(task)
+	m.mutex.Unlock()
+	task.cancel()
+	m.notifyTaskEvent(TaskCancelled, task, err)
+	task.finish(nil, err)
+	atomic.AddUint64(&m.metrics.dropped, 1)
+	return true
+}
+
+// onTaskEventDropped handles the events evicted by OverflowDropOldest.
+func (m *TaskRunner) onTaskEventDropped(event TaskEvent) {
+	m.mutex.Lock()
+	task, found := m.taskMap[event.Id]
+	m.mutex.Unlock()
+	if found && m.dropTask(task, ErrTaskDropped) {
+		klog.Warningf("TaskDropped TaskRunner:%s Id:%d Priority:%v", m.name, event.Id, event.Priority)
+	}
+}
+
+func (m *TaskRunner) AddRepeatingTask(closure TaskClosure, repeatingIntervalInMs int64, opts ...TaskOption) TaskItemId {
+	return m.AddRepeatingTaskContext(context.Background(), toContextClosure(closure), repeatingIntervalInMs, opts...)
+}
+
+// AddRepeatingTaskContext adds a repeating task which stops once ctx is done.
+// It returns 0 if repeatingIntervalInMs is not positive.
+func (m *TaskRunner) AddRepeatingTaskContext(ctx context.Context, closure TaskContextClosure, repeatingIntervalInMs int64, opts ...TaskOption) TaskItemId {
+	if repeatingIntervalInMs <= 0 {
+		klog.Errorf("AddTaskRejected TaskRunner:%s Error:invalid repeating interval %dms", m.name, repeatingIntervalInMs)
+		return 0
+	}
 	id := m.getUniqueTaskId()
+	options := newTaskOptions(ctx, opts)
+	options.scheduled(TaskKindRepeating, m.clock.Now())
 	repeatingTask := &repeatTaskItem{
-		id:                    id,
-		closure:               closure,
 		repeatingIntervalInMs: repeatingIntervalInMs,
-		stopCh:                make(chan bool, 1),
-		taskRunner:            m,
 	}
+	repeatingTask.init(id, toResultClosure(closure), options, m)
 	m.mutex.Lock()
+	if !m.isAcceptingTasks() {
+		m.mutex.Unlock()
+		repeatingTask.cancelFunc()
+		klog.Warningf("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, id, ErrTaskRunnerShutdown)
+		return 0
+	}
 	m.customTaskMap[id] = repeatingTask
 	m.mutex.Unlock()
 	repeatingTask.startSchedule()
 	return id
 }
 
-func (m *TaskRunner) AddDelayedTask(closure TaskClosure, delayedTimeInMs int64) TaskItemId {
+func (m *TaskRunner) AddDelayedTask(closure TaskClosure, delayedTimeInMs int64, opts ...TaskOption) TaskItemId {
+	return m.AddDelayedTaskContext(context.Background(), toContextClosure(closure), delayedTimeInMs, opts...)
+}
+
+// AddDelayedTaskContext adds a delayed task which is discarded if ctx is done
+// before the delay expires.
+func (m *TaskRunner) AddDelayedTaskContext(ctx context.Context, closure TaskContextClosure, delayedTimeInMs int64, opts ...TaskOption) TaskItemId {
+	return m.addDelayedTaskInternal(toResultClosure(closure), delayedTimeInMs, newTaskOptions(ctx, opts))
+}
+
+func (m *TaskRunner) addDelayedTaskInternal(closure TaskResultClosure, delayedTimeInMs int64, options *taskOptions) TaskItemId {
 	id := m.getUniqueTaskId()
+	options.scheduled(TaskKindDelayed, m.clock.Now())
 	delayedTask := &delayedTaskItem{
-		id:              id,
-		closure:         closure,
 		delayedTimeInMs: delayedTimeInMs,
-		stopCh:          make(chan bool, 1),
-		taskRunner:      m,
+		fireTime:        m.clock.Now().Add(time.Duration(delayedTimeInMs) * time.Millisecond),
 	}
+	delayedTask.init(id, closure, options, m)
 	m.mutex.Lock()
+	if !m.isAcceptingTasks() {
+		m.mutex.Unlock()
+		delayedTask.cancelFunc()
+		klog.Warningf("AddTaskRejected TaskRunner:%s Id:%d Error:%v", m.name, id, ErrTaskRunnerShutdown)
+		return 0
+	}
 	m.customTaskMap[id] = delayedTask
 	m.mutex.Unlock()
 	delayedTask.startSchedule()
 	return id
 }
 
+// AddCronTask adds a task fired according to the cron expression spec, see
+// CronSchedule for the accepted syntax. A nil location means time.Local.
+func (m *TaskRunner) AddCronTask(closure TaskClosure, spec string, location *time.Location, opts ...TaskOption) (TaskItemId, error) {
+	return m.AddCronTaskContext(context.Background(), toContextClosure(closure), spec, location, opts...)
+}
+
+// AddCronTaskContext adds a cron task which stops once ctx is done.
+func (m *TaskRunner) AddCronTaskContext(ctx context.Context, closure TaskContextClosure, spec string, location *time.Location, opts ...TaskOption) (TaskItemId, error) {
+	schedule, err := ParseCronSchedule(spec, location)
+	if err != nil {
+		return 0, err
+	}
+	if schedule.Next(m.clock.Now()).IsZero() {
+		return 0, fmt.Errorf("AddCronTask: %q never fires", spec)
+	}
+	id := m.getUniqueTaskId()
+	options := newTaskOptions(ctx, opts)
+	options.scheduled(TaskKindCron, m.clock.Now())
+	cronTask := &cronTaskItem{
+		schedule: schedule,
+	}
+	cronTask.init(id, toResultClosure(closure), options, m)
+	m.mutex.Lock()
+	if !m.isAcceptingTasks() {
+		m.mutex.Unlock()
+		cronTask.cancelFunc()
+		return 0, ErrTaskRunnerShutdown
+	}
+	m.customTaskMap[id] = cronTask
+	m.mutex.Unlock()
+	cronTask.startSchedule()
+	return id, nil
+}
+
+// NextFireTime returns when the repeating, delayed or cron task id fires next.
+func (m *TaskRunner) NextFireTime(id TaskItemId) (time.Time, bool) {
+	m.mutex.Lock()
+	task, found := m.customTaskMap[id]
+	m.mutex.Unlock()
+	if !found {
+		return time.Time{}, false
+	}
+	return task.nextFireTime(), true
+}
+
 func (m *TaskRunner) RemoveTask(id TaskItemId) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	if task, found := m.customTaskMap[id]; found {
 		go task.terminate()
 		delete(m.customTaskMap, id)
+		m.customTaskRemovedLocked(id, task)
+	}
+}
+
+// CancelTask stops the schedule of a repeating/delayed task and cancels the
+// ctx of the queued or running execution with the same id. It returns false
+// if the runner knows nothing about id.
+func (m *TaskRunner) CancelTask(id TaskItemId) bool {
+	m.mutex.Lock()
+	task, found := m.taskMap[id]
+	customTask, customFound := m.customTaskMap[id]
+	if customFound {
+		delete(m.customTaskMap, id)
+		m.customTaskRemovedLocked(id, customTask)
+	}
+	m.mutex.Unlock()
+	if customFound {
+		customTask.cancel()
+		go customTask.terminate()
+	}
+	if found {
+		task.cancel()
 	}
+	return found || customFound
 }
 
 func (m *TaskRunner) getUniqueTaskId() TaskItemId {
 	return TaskItemId(atomic.AddInt64(&m.taskClosureNextId, 1))
 }
 
-func (m *TaskRunner) getTask() *taskItem {
+func (m *TaskRunner) getTask(id TaskItemId) *taskItem {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	if len(m.taskMap) == 0 {
+	task, found := m.taskMap[id]
+	if !found || task.isRunning {
 		return nil
 	}
-	for _, task := range m.taskMap {
-		if !task.isRunning {
-			task.isRunning = true
-			return task
-		}
-	}
-	return nil
+	task.isRunning = true
+	return task
 }
 
-func (m *TaskRunner) removeTask(taskId TaskItemId) {
+func (m *TaskRunner) removeTask(task *taskItem) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
-	delete(m.taskMap, taskId)
+	delete(m.taskMap, task.id)
+	m.taskFinishedLocked(task)
 }
 
 func (m *TaskRunner) scheduleOneTask() {
+	defer close(m.schedulerDoneCh)
 	for {
 		select {
-		case _, ok := <-m.eventCh.RecvCh:
-			if ok {
-				task := m.getTask()
-				if task != nil {
-					m.pool.Submit(task.run)
-				} else {
-					klog.Errorf("Task in TaskRunner(%s) is nil", m.name)
+		case <-m.quitCh:
+			m.discardQueuedEvents()
+			return
+		default:
+		}
+		if !m.pauseGate.wait(m.quitCh) {
+			m.discardQueuedEvents()
+			return
+		}
+		if !m.slots.acquire(m.quitCh) {
+			m.discardQueuedEvents()
+			return
+		}
+		select {
+		case event, ok := <-m.eventCh.RecvCh:
+			if !ok {
+				m.slots.release()
+				klog.V(1).Infof("RecvCh in TaskRunner(%s) is closed", m.name)
+				return
+			}
+			// the runner may have been paused while waiting for the event, it
+			// is held until Resume and dropped by Shutdown meanwhile
+			if !m.pauseGate.wait(m.quitCh) {
+				m.slots.release()
+				m.discardQueuedEvents()
+				return
+			}
+			task := m.getTask(event.Id)
+			if task != nil {
+				m.metrics.waitTime.observe(m.clock.Since(event.EnqueueTime))
+				if !m.throttleTask(task) {
+					continue
 				}
+				task.queueWait = m.clock.Since(event.EnqueueTime)
+				m.submitTask(task)
 			} else {
-				klog.Errorf("RecvCh in TaskRunner(%s) is closed", m.name)
+				m.slots.release()
+				klog.Errorf("Task(%d) in TaskRunner(%s) is nil", event.Id, m.name)
 			}
+		case <-m.quitCh:
+			m.slots.release()
+			m.discardQueuedEvents()
+			return
+		}
+	}
+}
+
+// retryTask puts a failed task back to the queue once its backoff expired, the
+// task stays in taskMap meanwhile so Shutdown reports it as dropped.
+func (m *TaskRunner) retryTask(task *taskItem, attempt int, err error, backoff time.Duration) {
+	klog.Warningf("RetryTask TaskRunner:%s Id:%d Attempt:%d Backoff:%v Error:%v", m.name, task.id, attempt, backoff, err)
+	atomic.AddUint64(&m.metrics.retried, 1)
+	if task.retryPolicy.OnRetry != nil {
+		task.retryPolicy.OnRetry(task.id, attempt, err, backoff)
+	}
+	m.listeners.notify(&TaskLifecycleEvent{
+		Type:    TaskRetried,
+		Runner:  m.name,
+		Id:      task.id,
+		Attempt: attempt,
+		Time:    m.clock.Now(),
+		Err:     err,
+		Backoff: backoff,
+	})
+	m.mutex.Lock()
+	task.isRunning = false
+	m.mutex.Unlock()
+	m.slots.release()
+	m.runningWg.Done()
+
+	m.markTaskIdle(task)
+	readyCh := make(chan struct{})
+	// the task is busy again from the timer callback, which a FakeClock runs
+	// before it settles
+	timer := m.clock.AfterFunc(backoff, func() {
+		m.markTaskBusy(task)
+		close(readyCh)
+	})
+	go func() {
+		select {
+		case <-readyCh:
+		case <-task.ctx.Done():
+			timer.Stop()
 		}
+		m.markTaskBusy(task)
+		m.requeueTask(task)
+	}()
+	// the retry timer is armed, the closure is done
+	m.activity.addRunning(-1)
+}
+
+func (m *TaskRunner) requeueTask(task *taskItem) {
+	m.mutex.Lock()
+	if current, found := m.taskMap[task.id]; !found || current != task {
+		m.mutex.Unlock()
+		return
+	}
+	m.mutex.Unlock()
+	if !m.isAcceptingTasks() {
+		m.dropTask(task, ErrTaskRunnerShutdown)
+		return
+	}
+	if err := m.sendTaskEvent(task, false); err != nil {
+		m.dropTask(task, err)
+	}
+}
+
+func (m *TaskRunner) submitTask(task *taskItem) {
+	m.runningWg.Add(1)
+	m.activity.addRunning(1)
+	if err := m.pool.Submit(task.run); err != nil {
+		klog.Errorf("SubmitTaskFailed TaskRunner:%s Id:%d Error:%v", m.name, task.id, err)
+		m.dropTask(task, err)
+		m.slots.release()
+		m.runningWg.Done()
+		m.activity.addRunning(-1)
 	}
 }
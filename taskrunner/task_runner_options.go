@@ -0,0 +1,90 @@
+package taskrunner
+
+import (
+	"github.com/sohuno/gotools/timeutils"
+)
+
+type TaskRunnerOption func(options *taskRunnerOptions)
+
+type taskRunnerOptions struct {
+	priorityAgingInMs int64
+	queueCapacity     int
+	overflowPolicy    OverflowPolicy
+	store             TaskStore
+	rateLimiter       RateLimiter
+	categoryLimiters  map[string]RateLimiter
+	workerExpiryInMs  int64
+	nonblocking       bool
+	listeners         []TaskListener
+	tracer            TaskTracer
+	taskHistorySize   int
+	clock             timeutils.Clock
+	leaderLock        LeaderLock
+	leaderRenewInMs   int64
+	onLeaderChange    func(isLeader bool)
+	panicHandler      PanicHandler
+}
+
+// WithPriorityAging promotes a queued task one priority level for every
+// agingIntervalInMs it has been waiting, 0 disables aging.
+func WithPriorityAging(agingIntervalInMs int64) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.priorityAgingInMs = agingIntervalInMs
+	}
+}
+
+// WithQueueCapacity bounds the number of queued tasks, policy decides what
+// AddTask does when the queue is full. 0 means unbounded.
+func WithQueueCapacity(capacity int, policy OverflowPolicy) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.queueCapacity = capacity
+		options.overflowPolicy = policy
+	}
+}
+
+// WithTaskStore enables AddPersistentTask, the tasks left in store by a
+// previous process are replayed by Startup.
+func WithTaskStore(store TaskStore) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.store = store
+	}
+}
+
+// WithWorkerExpiry stops the pool workers which have been idle for
+// expiryInMs, rounded up to whole seconds. The ants default is 1s.
+func WithWorkerExpiry(expiryInMs int64) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.workerExpiryInMs = expiryInMs
+	}
+}
+
+// WithNonblocking makes AddTask fail with ErrTaskRunnerOverload instead of
+// queueing when the runner already holds as many tasks as it has workers.
+func WithNonblocking() TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.nonblocking = true
+	}
+}
+
+// WithClock makes the runner take all times and timers from clock, so that a
+// test can drive delayed, repeating and cron tasks with a timeutils.FakeClock.
+// A started runner settles on every fire of such a clock: Advance returns once
+// the tasks due on the way ran, one fire time after the other, or wait on the
+// clock for a retry or a rate limit. Closures must not wait on the clock.
+func WithClock(clock timeutils.Clock) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.clock = clock
+	}
+}
+
+func newTaskRunnerOptions(opts []TaskRunnerOption) *taskRunnerOptions {
+	options := &taskRunnerOptions{
+		taskHistorySize: 128,
+		clock:           timeutils.NewRealClock(),
+		panicHandler:    LogPanicHandler,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
@@ -0,0 +1,49 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCreateTaskRunnerInvalidSize(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if runner, err := CreateTaskRunner("invalid", 0); err == nil || runner != nil {
+			t.Fatalf("runner %v, error %v", runner, err)
+		}
+	}
+	// leave the goroutines of the other tests some time to exit
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > goroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if leaked := runtime.NumGoroutine() - goroutines; leaked > 0 {
+		t.Fatalf("%d goroutines leaked", leaked)
+	}
+}
+
+func TestNewTaskRunnerWithoutPool(t *testing.T) {
+	runner := NewTaskRunner("invalid", 0)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	future := runner.SubmitTask(context.Background(), TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}))
+	select {
+	case <-future.Done():
+	case <-time.After(time.Second):
+		t.Fatal("task of a runner without pool did not complete")
+	}
+	if _, err := future.Wait(); !errors.Is(err, ErrTaskRunnerNoPool) {
+		t.Fatal(err)
+	}
+	if _, err := runner.TryAddTask(TaskFunc(func() {})); !errors.Is(err, ErrTaskRunnerNoPool) {
+		t.Fatal(err)
+	}
+	if err := runner.Tune(2); !errors.Is(err, ErrTaskRunnerNoPool) {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+package taskrunner
+
+import (
+	"container/list"
+	"context"
+)
+
+// WithSerialKey serializes the tasks sharing key: they run one at a time in
+// submission order, while tasks with other keys keep running concurrently.
+// A task holds its key until it completes, including its retries. A drain
+// Shutdown waits for the parked tasks to be queued before closing the queue.
+func WithSerialKey(key string) TaskOption {
+	return func(options *taskOptions) {
+		options.serialKey = key
+	}
+}
+
+// serialKeyQueue holds the tasks waiting for a key, only the active task has
+// been sent to the TaskEventChannel.
+type serialKeyQueue struct {
+	active  *taskItem
+	pending *list.List
+}
+
+// acquireSerialKey returns true if task may be queued right away, otherwise
+// it is parked until the tasks before it with the same key completed.
+func (m *TaskRunner) acquireSerialKey(task *taskItem) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	queue, found := m.serialKeys[task.serialKey]
+	if !found {
+		m.serialKeys[task.serialKey] = &serialKeyQueue{
+			active:  task,
+			pending: list.New(),
+		}
+		return true
+	}
+	queue.pending.PushBack(task)
+	m.serialPending++
+	return false
+}
+
+// releaseSerialKey is called once task completed, it queues the next task
+// waiting for the same key. The event is sent from another goroutine: the
+// worker calling this still holds its slot, a blocking send to a full queue
+// would wait for the scheduler which waits for a slot.
+func (m *TaskRunner) releaseSerialKey(task *taskItem) {
+	m.mutex.Lock()
+	queue, found := m.serialKeys[task.serialKey]
+	if !found {
+		m.mutex.Unlock()
+		return
+	}
+	if queue.active != task {
+		// a parked task dropped before its turn
+		for element := queue.pending.Front(); element != nil; element = element.Next() {
+			if element.Value.(*taskItem) == task {
+				queue.pending.Remove(element)
+				m.serialTaskQueuedLocked()
+				break
+			}
+		}
+		m.mutex.Unlock()
+		return
+	}
+	front := queue.pending.Front()
+	if front == nil {
+		delete(m.serialKeys, task.serialKey)
+		m.mutex.Unlock()
+		return
+	}
+	next := queue.pending.Remove(front).(*taskItem)
+	queue.active = next
+	m.mutex.Unlock()
+	m.markTaskBusy(next)
+	go func() {
+		if err := m.sendTaskEvent(next, false); err != nil {
+			m.dropTask(next, err)
+		}
+		m.mutex.Lock()
+		m.serialTaskQueuedLocked()
+		m.mutex.Unlock()
+	}()
+}
+
+// serialTaskQueuedLocked counts down a parked task which has left its key
+// queue, the waiting drain Shutdown is woken once none is left.
+func (m *TaskRunner) serialTaskQueuedLocked() {
+	m.serialPending--
+	if m.serialPending == 0 && m.serialDrainedCh != nil {
+		close(m.serialDrainedCh)
+		m.serialDrainedCh = nil
+	}
+}
+
+// waitSerialTasksQueued blocks until the parked tasks have all been queued or
+// ctx is done.
+func (m *TaskRunner) waitSerialTasksQueued(ctx context.Context) error {
+	m.mutex.Lock()
+	if m.serialPending == 0 {
+		m.mutex.Unlock()
+		return nil
+	}
+	if m.serialDrainedCh == nil {
+		m.serialDrainedCh = make(chan struct{})
+	}
+	drainedCh := m.serialDrainedCh
+	m.mutex.Unlock()
+	select {
+	case <-drainedCh:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,54 @@
+package taskrunner
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSerialKeyFullQueue(t *testing.T) {
+	runner := NewTaskRunner("serial", 1, WithQueueCapacity(1, OverflowBlock))
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+
+	releaseCh := make(chan struct{})
+	startedCh := make(chan struct{})
+	runner.AddTask(TaskFunc(func() {
+		close(startedCh)
+		<-releaseCh
+	}), WithSerialKey("k"))
+	<-startedCh
+	second := runner.SubmitTask(context.Background(), TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	}), WithSerialKey("k"))
+	// fills the queue while the first task holds the only worker
+	runner.AddTask(TaskFunc(func() {}))
+	close(releaseCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := second.WaitContext(ctx); err != nil {
+		t.Fatalf("second task on the key did not run: %v", err)
+	}
+}
+
+func TestSerialKeyDrainShutdown(t *testing.T) {
+	runner := NewTaskRunner("serial", 2)
+	runner.Startup()
+
+	var runs int32
+	for i := 0; i < 3; i++ {
+		runner.AddTask(TaskFunc(func() {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&runs, 1)
+		}), WithSerialKey("k"))
+	}
+	report, err := runner.Shutdown(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.DroppedTasks) != 0 || atomic.LoadInt32(&runs) != 3 {
+		t.Fatalf("dropped %v, runs %d", report.DroppedTasks, runs)
+	}
+}
@@ -0,0 +1,173 @@
+package taskrunner
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds, in seconds, of the wait and run
+// time histograms.
+var DefaultLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60}
+
+// LatencyHistogram is a snapshot of a latency distribution. Counts[i] is the
+// number of observations less than or equal to Buckets[i], like the cumulative
+// buckets of Prometheus, observations above the last bound only show in Count.
+type LatencyHistogram struct {
+	Buckets []float64
+	Counts  []uint64
+	Count   uint64
+	Sum     float64
+}
+
+type TaskRunnerStats struct {
+	Name   string
+	Paused bool
+	// QueuedTasks are waiting for a worker, including tasks in retry backoff.
+	QueuedTasks int
+	// PendingEvents is the depth of the TaskEventChannel.
+	PendingEvents int
+	RunningTasks  int
+	// ScheduledTasks are the repeating, delayed and cron tasks.
+	ScheduledTasks int
+	PoolCapacity   int
+	// PoolRunning counts the worker goroutines of the pool, idle workers
+	// included until they expire.
+	PoolRunning int
+	PoolFree    int
+
+	SubmittedTasks uint64
+	CompletedTasks uint64
+	FailedTasks    uint64
+	PanickedTasks  uint64
+	RetriedTasks   uint64
+	DroppedTasks   uint64
+	// CoalescedTasks counts the submissions merged into a pending task by a
+	// dedup key, they are not counted as submitted.
+	CoalescedTasks uint64
+	// RateLimitedTasks counts the dispatches delayed by a rate limit.
+	RateLimitedTasks uint64
+
+	WaitTime LatencyHistogram
+	RunTime  LatencyHistogram
+	// RateLimitWait is the delay imposed by the rate limits, it is part of
+	// WaitTime unless a parked task was requeued.
+	RateLimitWait LatencyHistogram
+}
+
+type latencyHistogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newLatencyHistogram(buckets []float64) *latencyHistogram {
+	return &latencyHistogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (m *latencyHistogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	i := sort.SearchFloat64s(m.buckets, seconds)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if i < len(m.counts) {
+		m.counts[i]++
+	}
+	m.count++
+	m.sum += seconds
+}
+
+func (m *latencyHistogram) snapshot() LatencyHistogram {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	histogram := LatencyHistogram{
+		Buckets: append([]float64(nil), m.buckets...),
+		Counts:  make([]uint64, len(m.counts)),
+		Count:   m.count,
+		Sum:     m.sum,
+	}
+	var cumulative uint64
+	for i, count := range m.counts {
+		cumulative += count
+		histogram.Counts[i] = cumulative
+	}
+	return histogram
+}
+
+type taskRunnerMetrics struct {
+	submitted uint64
+	completed uint64
+	failed    uint64
+	panicked  uint64
+	retried   uint64
+	dropped   uint64
+	coalesced uint64
+	waitTime  *latencyHistogram
+	runTime   *latencyHistogram
+
+	rateLimited   uint64
+	rateLimitWait *latencyHistogram
+}
+
+func newTaskRunnerMetrics() *taskRunnerMetrics {
+	return &taskRunnerMetrics{
+		waitTime: newLatencyHistogram(DefaultLatencyBuckets),
+		runTime:  newLatencyHistogram(DefaultLatencyBuckets),
+
+		rateLimitWait: newLatencyHistogram(DefaultLatencyBuckets),
+	}
+}
+
+func (m *taskRunnerMetrics) taskCompleted(err error) {
+	atomic.AddUint64(&m.completed, 1)
+	if err != nil {
+		atomic.AddUint64(&m.failed, 1)
+	}
+}
+
+func (m *TaskRunner) Name() string {
+	return m.name
+}
+
+// Stats returns a snapshot of the queue, pool and task counters.
+func (m *TaskRunner) Stats() *TaskRunnerStats {
+	stats := &TaskRunnerStats{
+		Name:           m.name,
+		Paused:         m.IsPaused(),
+		PendingEvents:  m.eventCh.Len(),
+		SubmittedTasks: atomic.LoadUint64(&m.metrics.submitted),
+		CompletedTasks: atomic.LoadUint64(&m.metrics.completed),
+		FailedTasks:    atomic.LoadUint64(&m.metrics.failed),
+		PanickedTasks:  atomic.LoadUint64(&m.metrics.panicked),
+		RetriedTasks:   atomic.LoadUint64(&m.metrics.retried),
+		DroppedTasks:   atomic.LoadUint64(&m.metrics.dropped),
+		CoalescedTasks: atomic.LoadUint64(&m.metrics.coalesced),
+		WaitTime:       m.metrics.waitTime.snapshot(),
+		RunTime:        m.metrics.runTime.snapshot(),
+
+		RateLimitedTasks: atomic.LoadUint64(&m.metrics.rateLimited),
+		RateLimitWait:    m.metrics.rateLimitWait.snapshot(),
+	}
+	m.mutex.Lock()
+	for _, task := range m.taskMap {
+		if task.isRunning {
+			stats.RunningTasks++
+		} else {
+			stats.QueuedTasks++
+		}
+	}
+	stats.ScheduledTasks = len(m.customTaskMap)
+	m.mutex.Unlock()
+	if m.pool != nil {
+		stats.PoolCapacity = m.pool.Cap()
+		stats.PoolRunning = m.pool.Running()
+		stats.PoolFree = m.pool.Free()
+	}
+	return stats
+}
@@ -0,0 +1,219 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"k8s.io/klog/v2"
+)
+
+var (
+	ErrTaskStoreNotConfigured = errors.New("task store is not configured")
+)
+
+// PersistentTaskClosure is a task which can be written to a TaskStore and be
+// rebuilt after a restart by the factory registered for its TaskTypeName.
+type PersistentTaskClosure interface {
+	TaskResultClosure
+	TaskTypeName() string
+	MarshalTask() ([]byte, error)
+}
+
+type PersistentTaskFactory func(payload []byte) (PersistentTaskClosure, error)
+
+// TaskRecord is the durable form of a persistent task. Only the priority and
+// the timeout are kept from the TaskOptions, a retry policy is not persisted.
+type TaskRecord struct {
+	Seq            uint64       `json:"seq"`
+	TypeName       string       `json:"type"`
+	Payload        []byte       `json:"payload"`
+	Priority       TaskPriority `json:"priority"`
+	TimeoutInMs    int64        `json:"timeout_ms,omitempty"`
+	FireTimeInMs   int64        `json:"fire_time_ms,omitempty"`
+	SubmitTimeInMs int64        `json:"submit_time_ms"`
+}
+
+// TaskStore keeps the persistent tasks which have not completed yet.
+type TaskStore interface {
+	// Append durably stores record and assigns its Seq.
+	Append(record *TaskRecord) error
+	// Complete removes the record with seq.
+	Complete(seq uint64) error
+	// Load returns the records not completed, ordered by Seq.
+	Load() ([]*TaskRecord, error)
+}
+
+var (
+	taskTypeMutex     sync.RWMutex
+	taskTypeFactories = make(map[string]PersistentTaskFactory, 0)
+)
+
+// RegisterTaskType makes the persistent tasks of typeName recoverable, it is
+// meant to be called from init functions and panics on duplicated names.
+func RegisterTaskType(typeName string, factory PersistentTaskFactory) {
+	taskTypeMutex.Lock()
+	defer taskTypeMutex.Unlock()
+	if factory == nil {
+		panic("taskrunner: RegisterTaskType factory is nil for " + typeName)
+	}
+	if _, found := taskTypeFactories[typeName]; found {
+		panic("taskrunner: RegisterTaskType called twice for " + typeName)
+	}
+	taskTypeFactories[typeName] = factory
+}
+
+func getTaskTypeFactory(typeName string) (PersistentTaskFactory, bool) {
+	taskTypeMutex.RLock()
+	defer taskTypeMutex.RUnlock()
+	factory, found := taskTypeFactories[typeName]
+	return factory, found
+}
+
+// AddPersistentTask writes closure to the TaskStore before queueing it, the
+// record is removed once the closure has run or was cancelled. Tasks which
+// did not run or were abandoned by Shutdown or a crash are replayed by the
+// next runner built on the store, so the closure may run more than once and
+// should be idempotent. A task rejected by the queue is removed from the store and its
+// error is returned.
+func (m *TaskRunner) AddPersistentTask(closure PersistentTaskClosure, opts ...TaskOption) (*TaskFuture, error) {
+	record, options, err := m.newTaskRecord(closure, 0, opts)
+	if err != nil {
+		return nil, err
+	}
+	future, err := m.submitPersistentTask(record.Seq, closure, options)
+	if err != nil {
+		m.discardTaskRecord(record.Seq)
+		return nil, err
+	}
+	return future, nil
+}
+
+// AddPersistentDelayedTask is the persistent variant of AddDelayedTask, the
+// absolute fire time is stored so that a replayed task keeps its schedule.
+func (m *TaskRunner) AddPersistentDelayedTask(closure PersistentTaskClosure, delayedTimeInMs int64, opts ...TaskOption) (TaskItemId, error) {
+	record, options, err := m.newTaskRecord(closure, delayedTimeInMs, opts)
+	if err != nil {
+		return 0, err
+	}
+	id := m.addPersistentDelayedTask(record.Seq, closure, delayedTimeInMs, options)
+	if id == 0 {
+		m.discardTaskRecord(record.Seq)
+		return 0, ErrTaskRunnerShutdown
+	}
+	return id, nil
+}
+
+func (m *TaskRunner) newTaskRecord(closure PersistentTaskClosure, delayedTimeInMs int64, opts []TaskOption) (*TaskRecord, *taskOptions, error) {
+	if m.store == nil {
+		return nil, nil, ErrTaskStoreNotConfigured
+	}
+	if !m.isAcceptingTasks() {
+		return nil, nil, ErrTaskRunnerShutdown
+	}
+	typeName := closure.TaskTypeName()
+	if _, found := getTaskTypeFactory(typeName); !found {
+		return nil, nil, fmt.Errorf("AddPersistentTask: task type %q is not registered", typeName)
+	}
+	payload, err := closure.MarshalTask()
+	if err != nil {
+		return nil, nil, err
+	}
+	options := newTaskOptions(context.Background(), opts)
+	now := m.clock.Now()
+	record := &TaskRecord{
+		TypeName:       typeName,
+		Payload:        payload,
+		Priority:       options.priority,
+		TimeoutInMs:    options.timeoutInMs,
+		SubmitTimeInMs: now.UnixNano() / 1e6,
+	}
+	if delayedTimeInMs > 0 {
+		record.FireTimeInMs = record.SubmitTimeInMs + delayedTimeInMs
+	}
+	if err := m.store.Append(record); err != nil {
+		return nil, nil, err
+	}
+	return record, options, nil
+}
+
+func (m *TaskRunner) submitPersistentTask(seq uint64, closure PersistentTaskClosure, options *taskOptions) (*TaskFuture, error) {
+	options.onFinish = m.persistentTaskFinisher(seq)
+	return m.addTaskInternal(m.getUniqueTaskId(), closure, options, false)
+}
+
+func (m *TaskRunner) discardTaskRecord(seq uint64) {
+	if err := m.store.Complete(seq); err != nil {
+		klog.Errorf("CompletePersistentTaskFailed TaskRunner:%s Seq:%d Error:%v", m.name, seq, err)
+	}
+}
+
+func (m *TaskRunner) addPersistentDelayedTask(seq uint64, closure PersistentTaskClosure, delayedTimeInMs int64, options *taskOptions) TaskItemId {
+	options.onFinish = m.persistentTaskFinisher(seq)
+	return m.addDelayedTaskInternal(closure, delayedTimeInMs, options)
+}
+
+func (m *TaskRunner) persistentTaskFinisher(seq uint64) func(err error) {
+	return func(err error) {
+		if err != nil && isTaskNotRunError(err) {
+			// keep the record, the task is replayed by the next Startup
+			return
+		}
+		m.discardTaskRecord(seq)
+	}
+}
+
+// isTaskNotRunError returns true for the errors of a task which was not run
+// to completion by the runner: rejected, dropped or abandoned. A task
+// cancelled by its ctx, CancelTask or RemoveTask is done.
+func isTaskNotRunError(err error) bool {
+	return errors.Is(err, ErrTaskRunnerShutdown) ||
+		errors.Is(err, ErrTaskQueueFull) ||
+		errors.Is(err, ErrTaskDropped) ||
+		errors.Is(err, ErrTaskRunnerOverload) ||
+		errors.Is(err, ErrNotLeader) ||
+		errors.Is(err, ErrTaskAbandoned)
+}
+
+// recoverTasks replays the records the store held when the runner was built,
+// the tasks added since are queued already. It runs along the scheduler since
+// the records may not all fit in the queue. The records of the tasks the
+// queue rejects are kept for the next runner.
+func (m *TaskRunner) recoverTasks() {
+	records, err := m.storeRecords, m.storeLoadErr
+	m.storeRecords = nil
+	if err != nil {
+		klog.Errorf("LoadPersistentTasksFailed TaskRunner:%s Error:%v", m.name, err)
+		return
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Seq < records[j].Seq
+	})
+	nowInMs := m.clock.NowInMs()
+	for _, record := range records {
+		factory, found := getTaskTypeFactory(record.TypeName)
+		if !found {
+			klog.Errorf("RecoverPersistentTaskFailed TaskRunner:%s Seq:%d Error:unknown task type %q", m.name, record.Seq, record.TypeName)
+			continue
+		}
+		closure, err := factory(record.Payload)
+		if err != nil {
+			klog.Errorf("RecoverPersistentTaskFailed TaskRunner:%s Seq:%d Type:%s Error:%v", m.name, record.Seq, record.TypeName, err)
+			continue
+		}
+		options := newTaskOptions(context.Background(), []TaskOption{
+			WithPriority(record.Priority),
+			WithTimeout(record.TimeoutInMs),
+		})
+		if record.FireTimeInMs > nowInMs {
+			m.addPersistentDelayedTask(record.Seq, closure, record.FireTimeInMs-nowInMs, options)
+		} else {
+			if _, err := m.submitPersistentTask(record.Seq, closure, options); err != nil {
+				klog.Errorf("RecoverPersistentTaskFailed TaskRunner:%s Seq:%d Type:%s Error:%v", m.name, record.Seq, record.TypeName, err)
+			}
+		}
+	}
+	klog.Infof("RecoverPersistentTasks TaskRunner:%s Count:%d", m.name, len(records))
+}
@@ -0,0 +1,188 @@
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var storeTestRuns int32
+
+type storeTestClosure struct {
+	Block bool `json:"block"`
+}
+
+func (m *storeTestClosure) TaskTypeName() string {
+	return "store_test"
+}
+
+func (m *storeTestClosure) MarshalTask() ([]byte, error) {
+	return json.Marshal(m)
+}
+
+func (m *storeTestClosure) RunResult(ctx context.Context) (interface{}, error) {
+	if m.Block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	atomic.AddInt32(&storeTestRuns, 1)
+	return nil, nil
+}
+
+var storeTestOnce sync.Once
+
+func newStoreTestRunner(t *testing.T, path string, opts ...TaskRunnerOption) (*TaskRunner, *FileTaskStore) {
+	storeTestOnce.Do(func() {
+		RegisterTaskType("store_test", func(payload []byte) (PersistentTaskClosure, error) {
+			closure := &storeTestClosure{}
+			return closure, json.Unmarshal(payload, closure)
+		})
+	})
+	store, err := NewFileTaskStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewTaskRunner("store", 1, append(opts, WithTaskStore(store))...), store
+}
+
+func loadRecords(t *testing.T, store *FileTaskStore) int {
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(records)
+}
+
+func TestPersistentTaskRejected(t *testing.T) {
+	runner, store := newStoreTestRunner(t, filepath.Join(t.TempDir(), "tasks"), WithQueueCapacity(2, OverflowReject))
+	defer store.Close()
+	// not started, so the queue keeps what it accepted
+	var rejected int
+	for i := 0; i < 3; i++ {
+		if _, err := runner.AddPersistentTask(&storeTestClosure{}); errors.Is(err, ErrTaskQueueFull) {
+			rejected++
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rejected != 1 || loadRecords(t, store) != 2 {
+		t.Fatalf("rejected %d, records %d", rejected, loadRecords(t, store))
+	}
+	runner.Shutdown(context.Background(), false)
+	if loadRecords(t, store) != 2 {
+		t.Fatalf("records %d after shutdown", loadRecords(t, store))
+	}
+}
+
+func TestPersistentTaskRecoverOverCapacity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks")
+	runner, store := newStoreTestRunner(t, path)
+	for i := 0; i < 5; i++ {
+		if _, err := runner.AddPersistentTask(&storeTestClosure{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runner.Shutdown(context.Background(), false)
+	store.Close()
+
+	atomic.StoreInt32(&storeTestRuns, 0)
+	runner, store = newStoreTestRunner(t, path, WithQueueCapacity(2, OverflowBlock))
+	defer store.Close()
+	startedCh := make(chan struct{})
+	go func() {
+		runner.Startup()
+		close(startedCh)
+	}()
+	select {
+	case <-startedCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Startup blocked on the replay")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for loadRecords(t, store) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	runner.Shutdown(context.Background(), true)
+	if runs := atomic.LoadInt32(&storeTestRuns); runs != 5 || loadRecords(t, store) != 0 {
+		t.Fatalf("runs %d, records %d", runs, loadRecords(t, store))
+	}
+}
+
+func TestPersistentTaskAbandoned(t *testing.T) {
+	runner, store := newStoreTestRunner(t, filepath.Join(t.TempDir(), "tasks"))
+	defer store.Close()
+	runner.Startup()
+	future, err := runner.AddPersistentTask(&storeTestClosure{Block: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for future.Attempts() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	report, _ := runner.Shutdown(ctx, true)
+	if _, err := future.Wait(); !errors.Is(err, ErrTaskAbandoned) {
+		t.Fatal(err)
+	}
+	if len(report.AbandonedTasks) != 1 || loadRecords(t, store) != 1 {
+		t.Fatalf("abandoned %v, records %d", report.AbandonedTasks, loadRecords(t, store))
+	}
+}
+
+func TestPersistentTaskAddedBeforeStartup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks")
+	runner, store := newStoreTestRunner(t, path)
+	if _, err := runner.AddPersistentTask(&storeTestClosure{}); err != nil {
+		t.Fatal(err)
+	}
+	runner.Shutdown(context.Background(), false)
+	store.Close()
+
+	atomic.StoreInt32(&storeTestRuns, 0)
+	runner, store = newStoreTestRunner(t, path)
+	defer store.Close()
+	// one record left by the previous run, one added by this run before and
+	// one after Startup, each runs once
+	if _, err := runner.AddPersistentTask(&storeTestClosure{}); err != nil {
+		t.Fatal(err)
+	}
+	runner.Startup()
+	if _, err := runner.AddPersistentTask(&storeTestClosure{}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for loadRecords(t, store) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	runner.Shutdown(context.Background(), true)
+	if runs := atomic.LoadInt32(&storeTestRuns); runs != 3 || loadRecords(t, store) != 0 {
+		t.Fatalf("runs %d, records %d", runs, loadRecords(t, store))
+	}
+}
+
+func TestPersistentTaskCancelled(t *testing.T) {
+	runner, store := newStoreTestRunner(t, filepath.Join(t.TempDir(), "tasks"))
+	defer store.Close()
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	future, err := runner.AddPersistentTask(&storeTestClosure{Block: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for future.Attempts() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	runner.CancelTask(future.Id())
+	if _, err := future.Wait(); !errors.Is(err, context.Canceled) || errors.Is(err, ErrTaskAbandoned) {
+		t.Fatal(err)
+	}
+	if records := loadRecords(t, store); records != 0 {
+		t.Fatalf("records %d after cancel", records)
+	}
+}
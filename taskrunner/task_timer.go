@@ -0,0 +1,141 @@
+package taskrunner
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/sohuno/gotools/timeutils"
+)
+
+// taskTimer drives the repeating, delayed and cron tasks of a runner from a
+// min-heap of fire times and a single clock timer armed for the earliest one,
+// instead of a goroutine and a ticker per task.
+type taskTimer struct {
+	clock    timeutils.Clock
+	activity *taskActivity
+	mutex    sync.Mutex
+	entries  timerHeap
+	seq      uint64
+	armed    timeutils.Timer
+	armedAt  time.Time
+}
+
+type timerEntry struct {
+	when time.Time
+	// seq keeps the entries with the same fire time in schedule order.
+	seq   uint64
+	fire  func()
+	index int
+}
+
+func newTaskTimer(clock timeutils.Clock, activity *taskActivity) *taskTimer {
+	return &taskTimer{
+		clock:    clock,
+		activity: activity,
+	}
+}
+
+// schedule calls fire in a new goroutine at when, fire must not assume any
+// ordering with the other timers. The fire counts as activity until it
+// returned.
+func (m *taskTimer) schedule(when time.Time, fire func()) *timerEntry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.seq++
+	entry := &timerEntry{
+		when: when,
+		seq:  m.seq,
+		fire: fire,
+	}
+	heap.Push(&m.entries, entry)
+	if !when.After(m.clock.Now()) {
+		m.fireDueLocked()
+	}
+	if m.armed == nil || when.Before(m.armedAt) {
+		m.armLocked()
+	}
+	return entry
+}
+
+// cancel returns false if entry already fired or was cancelled.
+func (m *taskTimer) cancel(entry *timerEntry) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if entry == nil || entry.index < 0 {
+		return false
+	}
+	heap.Remove(&m.entries, entry.index)
+	return true
+}
+
+// armLocked arms the clock timer for the earliest entry, a timer armed for a
+// later entry is stopped. A stale timer which fires anyway finds nothing due.
+func (m *taskTimer) armLocked() {
+	if m.armed != nil {
+		m.armed.Stop()
+		m.armed = nil
+	}
+	if len(m.entries) == 0 {
+		return
+	}
+	m.armedAt = m.entries[0].when
+	m.armed = m.clock.AfterFunc(m.armedAt.Sub(m.clock.Now()), m.onTimer)
+}
+
+func (m *taskTimer) onTimer() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.fireDueLocked()
+	m.armed = nil
+	m.armLocked()
+}
+
+// fireDueLocked fires the due entries, the activity is counted here since the
+// fires run in their own goroutines after a FakeClock already moved on.
+func (m *taskTimer) fireDueLocked() {
+	now := m.clock.Now()
+	for len(m.entries) > 0 && !m.entries[0].when.After(now) {
+		entry := heap.Pop(&m.entries).(*timerEntry)
+		m.activity.addRunning(1)
+		go func() {
+			defer m.activity.addRunning(-1)
+			entry.fire()
+		}()
+	}
+}
+
+type timerHeap []*timerEntry
+
+func (h timerHeap) Len() int {
+	return len(h)
+}
+
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].when.Equal(h[j].when) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].when.Before(h[j].when)
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	entry := x.(*timerEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	entry.index = -1
+	*h = old[:n-1]
+	return entry
+}
@@ -0,0 +1,202 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sohuno/gotools/timeutils"
+)
+
+func newFakeClockRunner(size int, opts ...TaskRunnerOption) (*TaskRunner, *timeutils.FakeClock) {
+	clock := timeutils.NewFakeClock(time.Unix(1000, 0))
+	runner := NewTaskRunner("fake", size, append(opts, WithClock(clock))...)
+	runner.Startup()
+	return runner, clock
+}
+
+func TestFakeClockRepeatingTask(t *testing.T) {
+	for _, mode := range []RepeatMode{RepeatFixedRate, RepeatFixedDelay} {
+		runner, clock := newFakeClockRunner(2)
+		var runs int32
+		runner.AddRepeatingTask(TaskFunc(func() {
+			atomic.AddInt32(&runs, 1)
+		}), 1000, WithInitialDelay(1000), WithRepeatMode(mode))
+
+		clock.Advance(5 * time.Second)
+		if n := atomic.LoadInt32(&runs); n != 5 {
+			t.Fatalf("%v: %d runs after 5 intervals", mode, n)
+		}
+		clock.Advance(500 * time.Millisecond)
+		if n := atomic.LoadInt32(&runs); n != 5 {
+			t.Fatalf("%v: %d runs after 5.5 intervals", mode, n)
+		}
+		runner.Shutdown(context.Background(), false)
+	}
+}
+
+func TestFakeClockOverlapQueueOne(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	var runs int32
+	id := runner.AddRepeatingTask(TaskFunc(func() {
+		atomic.AddInt32(&runs, 1)
+	}), 1000, WithOverlapPolicy(OverlapQueueOne))
+
+	// the first run is queued right away
+	clock.Advance(0)
+	clock.Advance(3 * time.Second)
+	info, found := runner.GetTask(id)
+	if n := atomic.LoadInt32(&runs); n != 4 || !found || info.SkippedRuns != 0 {
+		t.Fatalf("%d runs, info %+v", n, info)
+	}
+}
+
+func TestFakeClockDelayedAndCronTasks(t *testing.T) {
+	runner, clock := newFakeClockRunner(2)
+	defer runner.Shutdown(context.Background(), false)
+	var delayed, cron int32
+	runner.AddDelayedTask(TaskFunc(func() {
+		atomic.AddInt32(&delayed, 1)
+	}), 90*1000)
+	if _, err := runner.AddCronTask(TaskFunc(func() {
+		atomic.AddInt32(&cron, 1)
+	}), "* * * * *", time.UTC); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Minute)
+	if atomic.LoadInt32(&delayed) != 0 || atomic.LoadInt32(&cron) != 1 {
+		t.Fatalf("delayed %d, cron %d after 1 minute", delayed, cron)
+	}
+	clock.Advance(time.Hour)
+	if atomic.LoadInt32(&delayed) != 1 || atomic.LoadInt32(&cron) != 61 {
+		t.Fatalf("delayed %d, cron %d after 61 minutes", delayed, cron)
+	}
+}
+
+func TestFakeClockRetryBackoff(t *testing.T) {
+	runner, clock := newFakeClockRunner(1)
+	defer runner.Shutdown(context.Background(), false)
+	future := runner.SubmitTask(context.Background(), TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("failed")
+	}), WithRetryPolicy(&RetryPolicy{
+		MaxAttempts:        3,
+		InitialBackoffInMs: 1000,
+		Multiplier:         2,
+	}))
+
+	clock.Advance(0)
+	if future.Attempts() != 1 {
+		t.Fatalf("%d attempts before the backoff", future.Attempts())
+	}
+	clock.Advance(time.Second)
+	if future.Attempts() != 2 {
+		t.Fatalf("%d attempts after 1s", future.Attempts())
+	}
+	clock.Advance(2 * time.Second)
+	if _, err := future.Wait(); err == nil || future.Attempts() != 3 {
+		t.Fatalf("%d attempts after 3s, error %v", future.Attempts(), err)
+	}
+}
+
+const benchmarkPendingTasks = 10000
+
+// pendingTasksMetrics reports the goroutines and the stack memory added since
+// it was created, B/op does not include the goroutine stacks.
+type pendingTasksMetrics struct {
+	goroutines int
+	stackInuse uint64
+}
+
+func newPendingTasksMetrics() *pendingTasksMetrics {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return &pendingTasksMetrics{
+		goroutines: runtime.NumGoroutine(),
+		stackInuse: stats.StackInuse,
+	}
+}
+
+func (m *pendingTasksMetrics) report(b *testing.B) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	b.ReportMetric(float64(runtime.NumGoroutine()-m.goroutines), "goroutines")
+	b.ReportMetric(float64(stats.StackInuse)-float64(m.stackInuse), "stack-B")
+}
+
+// BenchmarkDelayedTasksTimerHeap adds benchmarkPendingTasks delayed tasks
+// which stay pending, the timer heap needs no goroutine per task.
+func BenchmarkDelayedTasksTimerHeap(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		runner := NewTaskRunner("bench", 4)
+		runner.Startup()
+		metrics := newPendingTasksMetrics()
+		for j := 0; j < benchmarkPendingTasks; j++ {
+			runner.AddDelayedTask(TaskFunc(func() {}), 3600*1000)
+		}
+		metrics.report(b)
+		runner.Shutdown(context.Background(), false)
+	}
+}
+
+// BenchmarkDelayedTasksGoroutinePerTask is the same load with a goroutine and
+// a timer per task, as the runner did before the timer heap.
+func BenchmarkDelayedTasksGoroutinePerTask(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		stopCh := make(chan struct{})
+		var wg sync.WaitGroup
+		metrics := newPendingTasksMetrics()
+		for j := 0; j < benchmarkPendingTasks; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				timer := time.NewTimer(time.Hour)
+				defer timer.Stop()
+				select {
+				case <-timer.C:
+				case <-stopCh:
+				}
+			}()
+		}
+		metrics.report(b)
+		close(stopCh)
+		wg.Wait()
+	}
+}
+
+// BenchmarkTimerFireHeap measures scheduling and firing through the heap.
+func BenchmarkTimerFireHeap(b *testing.B) {
+	b.ReportAllocs()
+	timer := newTaskTimer(timeutils.NewRealClock(), newTaskActivity())
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	now := time.Now()
+	for i := 0; i < b.N; i++ {
+		timer.schedule(now.Add(time.Millisecond), wg.Done)
+	}
+	wg.Wait()
+}
+
+// BenchmarkTimerFireGoroutinePerTask is the same with a goroutine and a ticker
+// per fire.
+func BenchmarkTimerFireGoroutinePerTask(b *testing.B) {
+	b.ReportAllocs()
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	for i := 0; i < b.N; i++ {
+		go func() {
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+			<-ticker.C
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,65 @@
+package taskrunner
+
+import (
+	"context"
+	"time"
+)
+
+// TaskTracer observes every attempt of a task, package taskrunnerotel
+// implements it with OpenTelemetry spans.
+type TaskTracer interface {
+	// StartAttempt is called before the closure runs with the ctx the task
+	// was submitted with, the returned ctx is passed to the closure. end is
+	// called once with the outcome of the attempt.
+	StartAttempt(ctx context.Context, attempt *TaskAttempt) (context.Context, TaskAttemptEnd)
+}
+
+type TaskAttempt struct {
+	Runner    string
+	Id        TaskItemId
+	Attempt   int
+	Priority  TaskPriority
+	QueueWait time.Duration
+}
+
+// TaskAttemptEnd receives the error of the attempt, a *TaskPanicError if the
+// closure panicked. retrying is set with the backoff before the next attempt
+// when the task is retried.
+type TaskAttemptEnd func(err error, retrying bool, backoff time.Duration)
+
+// WithTaskTracer calls tracer around every run of a task, every attempt of a
+// retried task is traced on its own.
+func WithTaskTracer(tracer TaskTracer) TaskRunnerOption {
+	return func(options *taskRunnerOptions) {
+		options.tracer = tracer
+	}
+}
+
+// taskSpan is nil when tracing is disabled.
+type taskSpan struct {
+	end TaskAttemptEnd
+}
+
+func (m *taskItem) startSpan(ctx context.Context, attempt int) (context.Context, *taskSpan) {
+	tracer := m.taskRunner.tracer
+	if tracer == nil {
+		return ctx, nil
+	}
+	ctx, end := tracer.StartAttempt(ctx, &TaskAttempt{
+		Runner:    m.taskRunner.name,
+		Id:        m.id,
+		Attempt:   attempt,
+		Priority:  m.priority,
+		QueueWait: m.queueWait,
+	})
+	return ctx, &taskSpan{end: end}
+}
+
+// finish records the outcome of the run, backoff is the delay before the next
+// attempt when the task is retried.
+func (m *taskSpan) finish(err error, retrying bool, backoff time.Duration) {
+	if m == nil || m.end == nil {
+		return
+	}
+	m.end(err, retrying, backoff)
+}
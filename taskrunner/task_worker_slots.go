@@ -0,0 +1,70 @@
+package taskrunner
+
+import (
+	"sync"
+)
+
+// workerSlots mirrors the capacity of the ants pool so that the scheduler only
+// takes an event from the TaskEventChannel once a worker is free. Otherwise the
+// scheduler would block inside pool.Submit holding a task, and a more urgent
+// task queued in the meantime would have to wait behind it.
+type workerSlots struct {
+	mutex    sync.Mutex
+	capacity int
+	used     int
+	notifyCh chan struct{}
+}
+
+func newWorkerSlots(capacity int) *workerSlots {
+	return &workerSlots{
+		capacity: capacity,
+		notifyCh: make(chan struct{}, 1),
+	}
+}
+
+// acquire blocks until a slot is free or quitCh is closed.
+func (m *workerSlots) acquire(quitCh <-chan struct{}) bool {
+	for {
+		m.mutex.Lock()
+		if m.used < m.capacity {
+			m.used++
+			m.mutex.Unlock()
+			return true
+		}
+		m.mutex.Unlock()
+		select {
+		case <-m.notifyCh:
+		case <-quitCh:
+			return false
+		}
+	}
+}
+
+func (m *workerSlots) release() {
+	m.mutex.Lock()
+	m.used--
+	m.mutex.Unlock()
+	m.notify()
+}
+
+func (m *workerSlots) notify() {
+	select {
+	case m.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// resize changes the number of slots, the slots in use above a smaller
+// capacity are freed as their tasks complete.
+func (m *workerSlots) resize(capacity int) {
+	m.mutex.Lock()
+	m.capacity = capacity
+	m.mutex.Unlock()
+	m.notify()
+}
+
+func (m *workerSlots) size() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.capacity
+}
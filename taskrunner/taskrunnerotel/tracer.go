@@ -0,0 +1,65 @@
+// Package taskrunnerotel traces the tasks of a taskrunner.TaskRunner with
+// OpenTelemetry, it is kept out of package taskrunner so that the runners do
+// not depend on OpenTelemetry.
+package taskrunnerotel
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sohuno/gotools/taskrunner"
+)
+
+const tracerName = "github.com/sohuno/gotools/taskrunner"
+
+// WithTracerProvider starts a span around every run of a task. The span is a
+// child of the span in the ctx the task was submitted with, so a trace follows
+// the work fanned out to the runner. Every attempt of a retried task gets its
+// own span.
+func WithTracerProvider(provider trace.TracerProvider) taskrunner.TaskRunnerOption {
+	return taskrunner.WithTaskTracer(NewTaskTracer(provider))
+}
+
+type taskTracer struct {
+	tracer trace.Tracer
+}
+
+func NewTaskTracer(provider trace.TracerProvider) taskrunner.TaskTracer {
+	return &taskTracer{
+		tracer: provider.Tracer(tracerName),
+	}
+}
+
+func (m *taskTracer) StartAttempt(ctx context.Context, attempt *taskrunner.TaskAttempt) (context.Context, taskrunner.TaskAttemptEnd) {
+	ctx, span := m.tracer.Start(ctx, "taskrunner.task",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("taskrunner.name", attempt.Runner),
+			attribute.Int64("taskrunner.task.id", int64(attempt.Id)),
+			attribute.Int("taskrunner.task.attempt", attempt.Attempt),
+			attribute.String("taskrunner.task.priority", attempt.Priority.String()),
+			attribute.Int64("taskrunner.task.queue_wait_ms", attempt.QueueWait.Milliseconds()),
+		))
+	return ctx, func(err error, retrying bool, backoff time.Duration) {
+		if panicErr, ok := err.(*taskrunner.TaskPanicError); ok {
+			span.AddEvent("panic", trace.WithAttributes(
+				attribute.String("taskrunner.panic.value", fmt.Sprint(panicErr.Value)),
+			))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		if retrying {
+			span.AddEvent("retry", trace.WithAttributes(
+				attribute.Int64("taskrunner.retry.backoff_ms", backoff.Milliseconds()),
+			))
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,98 @@
+package taskrunnerotel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/sohuno/gotools/taskrunner"
+)
+
+func spanAttribute(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func spanEvent(span tracetest.SpanStub, name string) (sdktrace.Event, bool) {
+	for _, event := range span.Events {
+		if event.Name == name {
+			return event, true
+		}
+	}
+	return sdktrace.Event{}, false
+}
+
+func TestTaskSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	runner := taskrunner.NewTaskRunner("traced", 1, WithTracerProvider(provider))
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+
+	// hold the only worker so that the traced task waits in the queue
+	releaseCh := make(chan struct{})
+	blocker := runner.SubmitTask(context.Background(), taskrunner.TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		<-releaseCh
+		return nil, nil
+	}))
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	attempts := 0
+	future := runner.SubmitTask(ctx, taskrunner.TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		attempts++
+		if attempts == 1 {
+			panic("boom")
+		}
+		return nil, nil
+	}), taskrunner.WithRetryPolicy(&taskrunner.RetryPolicy{MaxAttempts: 2, InitialBackoffInMs: 10}))
+	time.Sleep(50 * time.Millisecond)
+	close(releaseCh)
+	blocker.Wait()
+	if _, err := future.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var attemptSpans []tracetest.SpanStub
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "taskrunner.task" && span.Parent.SpanID() == parent.SpanContext().SpanID() {
+			attemptSpans = append(attemptSpans, span)
+		}
+	}
+	if len(attemptSpans) != 2 {
+		t.Fatalf("%d attempt spans under the submit span, want 2", len(attemptSpans))
+	}
+	first, second := attemptSpans[0], attemptSpans[1]
+	if first.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+		t.Fatal("attempt span not in the trace of the submit ctx")
+	}
+	if value, ok := spanAttribute(first, "taskrunner.task.queue_wait_ms"); !ok || value.AsInt64() < 40 {
+		t.Fatalf("queue_wait_ms %v, want >= 40", value.AsInt64())
+	}
+	if value, ok := spanAttribute(second, "taskrunner.task.attempt"); !ok || value.AsInt64() != 2 {
+		t.Fatalf("attempt %v, want 2", value.AsInt64())
+	}
+
+	event, ok := spanEvent(first, "panic")
+	if !ok || event.Attributes[0].Value.AsString() != "boom" {
+		t.Fatalf("panic event %+v", first.Events)
+	}
+	event, ok = spanEvent(first, "retry")
+	if !ok || event.Attributes[0].Key != "taskrunner.retry.backoff_ms" || event.Attributes[0].Value.AsInt64() != 10 {
+		t.Fatalf("retry event %+v", first.Events)
+	}
+	if first.Status.Code != codes.Error {
+		t.Fatalf("first attempt status %v, want error", first.Status.Code)
+	}
+	if _, ok := spanEvent(second, "retry"); ok || second.Status.Code == codes.Error {
+		t.Fatalf("second attempt events %+v status %v", second.Events, second.Status.Code)
+	}
+}
@@ -0,0 +1,141 @@
+// Package taskrunnerprom exports the stats of task runners as Prometheus
+// metrics, it is kept out of package taskrunner so that the runners do not
+// depend on the Prometheus client.
+package taskrunnerprom
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sohuno/gotools/taskrunner"
+)
+
+// StatsSource is what the collector needs from a runner, *taskrunner.TaskRunner
+// implements it.
+type StatsSource interface {
+	Stats() *taskrunner.TaskRunnerStats
+}
+
+// TaskRunnerCollector exports the Stats of a set of TaskRunners as Prometheus
+// metrics labelled with the runner name, register it with
+// prometheus.MustRegister(collector).
+type TaskRunnerCollector struct {
+	mutex   sync.Mutex
+	runners map[string]StatsSource
+
+	queuedDesc      *prometheus.Desc
+	runningDesc     *prometheus.Desc
+	pendingDesc     *prometheus.Desc
+	scheduledDesc   *prometheus.Desc
+	poolCapDesc     *prometheus.Desc
+	poolWorkersDesc *prometheus.Desc
+	submittedDesc   *prometheus.Desc
+	completedDesc   *prometheus.Desc
+	failedDesc      *prometheus.Desc
+	panickedDesc    *prometheus.Desc
+	retriedDesc     *prometheus.Desc
+	droppedDesc     *prometheus.Desc
+	coalescedDesc   *prometheus.Desc
+	rateLimitedDesc *prometheus.Desc
+	waitTimeDesc    *prometheus.Desc
+	runTimeDesc     *prometheus.Desc
+	rateWaitDesc    *prometheus.Desc
+}
+
+func NewTaskRunnerCollector(namespace string, runners ...StatsSource) *TaskRunnerCollector {
+	desc := func(name string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "taskrunner", name), help, []string{"runner"}, nil)
+	}
+	collector := &TaskRunnerCollector{
+		runners:         make(map[string]StatsSource, 0),
+		queuedDesc:      desc("queued_tasks", "Tasks waiting for a worker."),
+		runningDesc:     desc("running_tasks", "Tasks currently running."),
+		pendingDesc:     desc("pending_events", "Depth of the task event channel."),
+		scheduledDesc:   desc("scheduled_tasks", "Repeating, delayed and cron tasks."),
+		poolCapDesc:     desc("pool_capacity", "Capacity of the worker pool."),
+		poolWorkersDesc: desc("pool_workers", "Worker goroutines alive in the pool."),
+		submittedDesc:   desc("submitted_tasks_total", "Tasks submitted to the runner."),
+		completedDesc:   desc("completed_tasks_total", "Tasks completed, successfully or not."),
+		failedDesc:      desc("failed_tasks_total", "Tasks completed with an error."),
+		panickedDesc:    desc("panicked_tasks_total", "Task runs which panicked."),
+		retriedDesc:     desc("retried_tasks_total", "Task runs rescheduled by a retry policy."),
+		droppedDesc:     desc("dropped_tasks_total", "Tasks dropped without running."),
+		coalescedDesc:   desc("coalesced_tasks_total", "Submissions merged into a pending task."),
+		rateLimitedDesc: desc("rate_limited_tasks_total", "Dispatches delayed by a rate limit."),
+		waitTimeDesc:    desc("task_wait_seconds", "Time tasks spent queued before running."),
+		runTimeDesc:     desc("task_run_seconds", "Time tasks spent running."),
+		rateWaitDesc:    desc("rate_limit_wait_seconds", "Delay imposed on dispatches by the rate limits."),
+	}
+	for _, runner := range runners {
+		collector.Add(runner)
+	}
+	return collector
+}
+
+// Add starts collecting runner, a runner with the same name is replaced.
+func (m *TaskRunnerCollector) Add(runner StatsSource) {
+	name := runner.Stats().Name
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.runners[name] = runner
+}
+
+func (m *TaskRunnerCollector) Remove(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.runners, name)
+}
+
+func (m *TaskRunnerCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		m.queuedDesc, m.runningDesc, m.pendingDesc, m.scheduledDesc, m.poolCapDesc, m.poolWorkersDesc,
+		m.submittedDesc, m.completedDesc, m.failedDesc, m.panickedDesc, m.retriedDesc, m.droppedDesc,
+		m.coalescedDesc, m.rateLimitedDesc, m.waitTimeDesc, m.runTimeDesc, m.rateWaitDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (m *TaskRunnerCollector) Collect(ch chan<- prometheus.Metric) {
+	m.mutex.Lock()
+	runners := make([]StatsSource, 0, len(m.runners))
+	for _, runner := range m.runners {
+		runners = append(runners, runner)
+	}
+	m.mutex.Unlock()
+
+	for _, runner := range runners {
+		stats := runner.Stats()
+		gauge := func(desc *prometheus.Desc, value int) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), stats.Name)
+		}
+		counter := func(desc *prometheus.Desc, value uint64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value), stats.Name)
+		}
+		histogram := func(desc *prometheus.Desc, value taskrunner.LatencyHistogram) {
+			buckets := make(map[float64]uint64, len(value.Buckets))
+			for i, bound := range value.Buckets {
+				buckets[bound] = value.Counts[i]
+			}
+			ch <- prometheus.MustNewConstHistogram(desc, value.Count, value.Sum, buckets, stats.Name)
+		}
+		gauge(m.queuedDesc, stats.QueuedTasks)
+		gauge(m.runningDesc, stats.RunningTasks)
+		gauge(m.pendingDesc, stats.PendingEvents)
+		gauge(m.scheduledDesc, stats.ScheduledTasks)
+		gauge(m.poolCapDesc, stats.PoolCapacity)
+		gauge(m.poolWorkersDesc, stats.PoolRunning)
+		counter(m.submittedDesc, stats.SubmittedTasks)
+		counter(m.completedDesc, stats.CompletedTasks)
+		counter(m.failedDesc, stats.FailedTasks)
+		counter(m.panickedDesc, stats.PanickedTasks)
+		counter(m.retriedDesc, stats.RetriedTasks)
+		counter(m.droppedDesc, stats.DroppedTasks)
+		counter(m.coalescedDesc, stats.CoalescedTasks)
+		counter(m.rateLimitedDesc, stats.RateLimitedTasks)
+		histogram(m.waitTimeDesc, stats.WaitTime)
+		histogram(m.runTimeDesc, stats.RunTime)
+		histogram(m.rateWaitDesc, stats.RateLimitWait)
+	}
+}
@@ -0,0 +1,41 @@
+package taskrunnerprom
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/sohuno/gotools/taskrunner"
+)
+
+func TestTaskRunnerCollector(t *testing.T) {
+	runner := taskrunner.NewTaskRunner("stats", 2)
+	runner.Startup()
+	defer runner.Shutdown(context.Background(), false)
+	runner.SubmitTask(context.Background(), taskrunner.TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, nil
+	})).Wait()
+	runner.SubmitTask(context.Background(), taskrunner.TaskResultFunc(func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("failed")
+	})).Wait()
+
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(NewTaskRunnerCollector("agent", runner))
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP agent_taskrunner_failed_tasks_total Tasks completed with an error.
+# TYPE agent_taskrunner_failed_tasks_total counter
+agent_taskrunner_failed_tasks_total{runner="stats"} 1
+# HELP agent_taskrunner_submitted_tasks_total Tasks submitted to the runner.
+# TYPE agent_taskrunner_submitted_tasks_total counter
+agent_taskrunner_submitted_tasks_total{runner="stats"} 2
+`), "agent_taskrunner_failed_tasks_total", "agent_taskrunner_submitted_tasks_total"); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := testutil.GatherAndCount(registry); err != nil || count != 17 {
+		t.Fatalf("%d metrics, error %v", count, err)
+	}
+}
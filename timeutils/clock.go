@@ -0,0 +1,359 @@
+package timeutils
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock extends TimeUtils with the timers of package time, so that code
+// waiting on time can be driven by a FakeClock in tests.
+type Clock interface {
+	TimeUtils
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	// AfterFunc calls f once d elapsed, C() of the returned Timer is nil.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Settler is implemented by the clocks which can wait for the work started by
+// their timers. The funcs added with AddSettleFunc are called after every
+// timer fired and are expected to return once that work completed or waits on
+// the clock again, the returned func removes f.
+type Settler interface {
+	AddSettleFunc(f func()) (remove func())
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// RealClock is the Clock of package time.
+type RealClock struct {
+	TimeUtilsImpl
+}
+
+func NewRealClock() *RealClock {
+	return &RealClock{}
+}
+
+func (m *RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (m *RealClock) Since(t time.Time) time.Duration {
+	return time.Since(t)
+}
+
+func (m *RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (m *RealClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+func (m *RealClock) NewTimer(d time.Duration) Timer {
+	return &realTimer{timer: time.NewTimer(d)}
+}
+
+func (m *RealClock) NewTicker(d time.Duration) Ticker {
+	return &realTicker{ticker: time.NewTicker(d)}
+}
+
+func (m *RealClock) AfterFunc(d time.Duration, f func()) Timer {
+	return &realTimer{timer: time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	timer *time.Timer
+}
+
+func (m *realTimer) C() <-chan time.Time {
+	return m.timer.C
+}
+
+func (m *realTimer) Stop() bool {
+	return m.timer.Stop()
+}
+
+func (m *realTimer) Reset(d time.Duration) bool {
+	return m.timer.Reset(d)
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (m *realTicker) C() <-chan time.Time {
+	return m.ticker.C
+}
+
+func (m *realTicker) Stop() {
+	m.ticker.Stop()
+}
+
+func (m *realTicker) Reset(d time.Duration) {
+	m.ticker.Reset(d)
+}
+
+// FakeClock only moves when Advance or Set is called. The timers which expire
+// on the way fire in order, each at its own time, and the AfterFunc callbacks
+// have returned when Advance returns. FakeClock is a Settler: after each timer
+// the settle funcs are called before the clock moves on to the next one.
+type FakeClock struct {
+	mutex       sync.Mutex
+	now         time.Time
+	timers      []*fakeTimer
+	seq         uint64
+	waitersCh   chan struct{}
+	settleSeq   uint64
+	settleFuncs map[uint64]func()
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:       now,
+		waitersCh: make(chan struct{}),
+	}
+}
+
+func (m *FakeClock) Now() time.Time {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.now
+}
+
+func (m *FakeClock) NowInMs() int64 {
+	return m.Now().UnixNano() / 1e6
+}
+
+func (m *FakeClock) NowInSec() int64 {
+	return m.Now().UnixNano() / 1e9
+}
+
+func (m *FakeClock) Since(t time.Time) time.Duration {
+	return m.Now().Sub(t)
+}
+
+func (m *FakeClock) After(d time.Duration) <-chan time.Time {
+	return m.NewTimer(d).C()
+}
+
+func (m *FakeClock) Sleep(d time.Duration) {
+	<-m.After(d)
+}
+
+func (m *FakeClock) NewTimer(d time.Duration) Timer {
+	timer := &fakeTimer{clock: m, ch: make(chan time.Time, 1)}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addLocked(timer, d)
+	return timer
+}
+
+func (m *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	timer := &fakeTimer{clock: m, ch: make(chan time.Time, 1), period: d}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addLocked(timer, d)
+	return &fakeTicker{timer: timer}
+}
+
+func (m *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	timer := &fakeTimer{clock: m, fn: f}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.addLocked(timer, d)
+	return timer
+}
+
+// Advance moves the clock forward by d, firing the expired timers.
+func (m *FakeClock) Advance(d time.Duration) {
+	m.Set(m.Now().Add(d))
+}
+
+// Set moves the clock to t, firing the expired timers. The clock never goes
+// backwards. The settle funcs are called before the clock moves and after
+// every timer.
+func (m *FakeClock) Set(t time.Time) {
+	m.settle()
+	for {
+		m.mutex.Lock()
+		if len(m.timers) == 0 || m.timers[0].when.After(t) {
+			if t.After(m.now) {
+				m.now = t
+			}
+			m.mutex.Unlock()
+			return
+		}
+		timer := m.timers[0]
+		m.removeLocked(timer)
+		if timer.when.After(m.now) {
+			m.now = timer.when
+		}
+		now := m.now
+		if timer.period > 0 {
+			m.addLocked(timer, timer.period)
+		}
+		m.mutex.Unlock()
+		timer.fire(now)
+		m.settle()
+	}
+}
+
+func (m *FakeClock) AddSettleFunc(f func()) (remove func()) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.settleFuncs == nil {
+		m.settleFuncs = make(map[uint64]func())
+	}
+	m.settleSeq++
+	seq := m.settleSeq
+	m.settleFuncs[seq] = f
+	return func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		delete(m.settleFuncs, seq)
+	}
+}
+
+func (m *FakeClock) settle() {
+	m.mutex.Lock()
+	funcs := make([]func(), 0, len(m.settleFuncs))
+	for _, f := range m.settleFuncs {
+		funcs = append(funcs, f)
+	}
+	m.mutex.Unlock()
+	for _, f := range funcs {
+		f()
+	}
+}
+
+// Waiters returns the number of pending timers, tickers included.
+func (m *FakeClock) Waiters() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.timers)
+}
+
+// BlockUntil waits until at least n timers are pending, so that a test can
+// Advance once the code under test started waiting.
+func (m *FakeClock) BlockUntil(n int) {
+	for {
+		m.mutex.Lock()
+		if len(m.timers) >= n {
+			m.mutex.Unlock()
+			return
+		}
+		waitersCh := m.waitersCh
+		m.mutex.Unlock()
+		<-waitersCh
+	}
+}
+
+func (m *FakeClock) addLocked(timer *fakeTimer, d time.Duration) {
+	m.seq++
+	timer.when = m.now.Add(d)
+	timer.seq = m.seq
+	timer.active = true
+	i := sort.Search(len(m.timers), func(i int) bool {
+		other := m.timers[i]
+		return other.when.After(timer.when) || (other.when.Equal(timer.when) && other.seq > timer.seq)
+	})
+	m.timers = append(m.timers, nil)
+	copy(m.timers[i+1:], m.timers[i:])
+	m.timers[i] = timer
+	close(m.waitersCh)
+	m.waitersCh = make(chan struct{})
+}
+
+func (m *FakeClock) removeLocked(timer *fakeTimer) bool {
+	if !timer.active {
+		return false
+	}
+	timer.active = false
+	for i, other := range m.timers {
+		if other == timer {
+			m.timers = append(m.timers[:i], m.timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+type fakeTimer struct {
+	clock  *FakeClock
+	when   time.Time
+	seq    uint64
+	period time.Duration
+	active bool
+	ch     chan time.Time
+	fn     func()
+}
+
+func (m *fakeTimer) C() <-chan time.Time {
+	return m.ch
+}
+
+func (m *fakeTimer) Stop() bool {
+	m.clock.mutex.Lock()
+	defer m.clock.mutex.Unlock()
+	return m.clock.removeLocked(m)
+}
+
+func (m *fakeTimer) Reset(d time.Duration) bool {
+	m.clock.mutex.Lock()
+	defer m.clock.mutex.Unlock()
+	active := m.clock.removeLocked(m)
+	m.clock.addLocked(m, d)
+	return active
+}
+
+func (m *fakeTimer) fire(now time.Time) {
+	if m.fn != nil {
+		m.fn()
+		return
+	}
+	// like the timers of package time a tick is dropped if the previous one
+	// has not been received yet
+	select {
+	case m.ch <- now:
+	default:
+	}
+}
+
+type fakeTicker struct {
+	timer *fakeTimer
+}
+
+func (m *fakeTicker) C() <-chan time.Time {
+	return m.timer.ch
+}
+
+func (m *fakeTicker) Stop() {
+	m.timer.Stop()
+}
+
+func (m *fakeTicker) Reset(d time.Duration) {
+	m.timer.clock.mutex.Lock()
+	defer m.timer.clock.mutex.Unlock()
+	m.timer.clock.removeLocked(m.timer)
+	m.timer.period = d
+	m.timer.clock.addLocked(m.timer, d)
+}
@@ -0,0 +1,70 @@
+package timeutils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClockAdvance(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewFakeClock(start)
+	var fired []time.Duration
+	clock.AfterFunc(3*time.Second, func() {
+		fired = append(fired, clock.Since(start))
+	})
+	clock.AfterFunc(time.Second, func() {
+		fired = append(fired, clock.Since(start))
+		// a timer added by a callback fires within the same Advance
+		clock.AfterFunc(time.Second, func() {
+			fired = append(fired, clock.Since(start))
+		})
+	})
+	timer := clock.NewTimer(10 * time.Second)
+
+	clock.Advance(5 * time.Second)
+	if len(fired) != 3 || fired[0] != time.Second || fired[1] != 2*time.Second || fired[2] != 3*time.Second {
+		t.Fatalf("fired at %v", fired)
+	}
+	if clock.Since(start) != 5*time.Second || clock.Waiters() != 1 {
+		t.Fatalf("now %v, waiters %d", clock.Since(start), clock.Waiters())
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early")
+	default:
+	}
+	clock.Advance(5 * time.Second)
+	select {
+	case now := <-timer.C():
+		if now.Sub(start) != 10*time.Second {
+			t.Fatalf("timer fired at %v", now.Sub(start))
+		}
+	default:
+		t.Fatal("timer did not fire")
+	}
+}
+
+func TestFakeClockSettle(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	ticker := clock.NewTicker(time.Second)
+	defer ticker.Stop()
+	var settles, ticks int
+	remove := clock.AddSettleFunc(func() {
+		settles++
+		select {
+		case <-ticker.C():
+			ticks++
+		default:
+		}
+	})
+	clock.Advance(3 * time.Second)
+	// once before moving and once after every tick
+	if settles != 4 || ticks != 3 {
+		t.Fatalf("settles %d, ticks %d", settles, ticks)
+	}
+	remove()
+	clock.Advance(time.Second)
+	if settles != 4 {
+		t.Fatalf("settles %d after remove", settles)
+	}
+}